@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Sebastien Leger
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/go-redis/redis/v8"
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+var acksCmd = &cobra.Command{
+	Use:   "acks",
+	Short: "Lists the topology versions applied by stream subscribers",
+	Long: `
+The acks command reads the acknowledgements of subscribers running in stream mode and prints,
+for each consumer group and consumer, the last topology version applied along with the latest
+published version.`,
+	Run: acks,
+}
+
+func newAcksCmd() *cobra.Command {
+	cmd := acksCmd
+	flags := cmd.Flags()
+	addAcksFlags(flags)
+	return cmd
+}
+
+func addAcksFlags(flags *flag.FlagSet) {
+	flags.String("addr", "redis:6379", heredoc.Doc(`
+Redis server address`))
+	flags.String("topic", defaultRedisTopic, heredoc.Doc(`
+The Redis stream where topologies are published`))
+	flags.String("user", "", heredoc.Doc(`
+Redis user`))
+	flags.String("password", "", heredoc.Doc(`
+Redis password`))
+}
+
+func init() {
+	cmd := newAcksCmd()
+	registerCommandFlags(cmd)
+	rootCmd.AddCommand(cmd)
+}
+
+func acks(cmd *cobra.Command, args []string) {
+	loadConfig(cmd)
+	addr, _ := cmd.Flags().GetString("addr")
+	topic, _ := cmd.Flags().GetString("topic")
+	user, _ := cmd.Flags().GetString("user")
+	password, _ := cmd.Flags().GetString("password")
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Username: user,
+		Password: password,
+	})
+	defer redisClient.Close()
+	ctx := context.Background()
+	stream := pkg.NewTopologyStream(redisClient, topic)
+	latest, err := stream.Latest(ctx)
+	if err != nil {
+		log.Errorf("Cannot read latest topology: %v", err)
+		os.Exit(1)
+	}
+	acks, err := stream.Acks(ctx)
+	if err != nil {
+		log.Errorf("Cannot read acknowledgements: %v", err)
+		os.Exit(1)
+	}
+	out := struct {
+		Latest string            `json:"latest,omitempty"`
+		Acks   []pkg.TopologyAck `json:"acks"`
+	}{Acks: acks}
+	if latest != nil {
+		out.Latest = latest.Version
+	}
+	data, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(data))
+}
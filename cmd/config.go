@@ -0,0 +1,197 @@
+/*
+Copyright © 2021 Sebastien Leger
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/server"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspects the configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the config file",
+	Long: `
+The validate command reads the config file like the p2p command does and prints every problem
+found at once: unknown keys, values out of range, malformed addresses and URLs, and settings that
+contradict each other. It exits with status 1 if the config is invalid.`,
+	Run: configValidate,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Prints the effective config",
+	Long: `
+The show command prints every config key with its effective value and where it came from.
+Values are layered in increasing order of precedence: defaults, the config file, P2P_*
+environment variables (eg. P2P_SERVER_MAX_PEERS for server.max-peers) and command line flags.
+Keys derived from the network, such as server.magic, show the source of the network.
+The flags of the acks, fetch, push, snapshot and subscribe sections are listed too, eg. fetch.max.
+Deprecated keys that are still set are listed too.
+Secrets, such as passwords and tokens, are masked.`,
+	Run: configShow,
+}
+
+func init() {
+	addNetworkFlag(configShowCmd.Flags(), "")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// skipConfigFlag tells the flags that are not settings of a command
+func skipConfigFlag(name string) bool {
+	return name == "help" || name == "config"
+}
+
+// serverFlags are the flags of the p2p and config show commands bound to a key of the config
+var serverFlags = map[string]string{
+	"network": "server.network",
+}
+
+// configCommands are the commands whose flags can be set in a section of the config file
+var configCommands = make([]*cobra.Command, 0)
+
+// bindServerFlags binds the server flags of flags to their config key, and returns the keys
+// set on the command line. Flags are bound when a command runs since viper binds a key to a single flag.
+func bindServerFlags(flags *flag.FlagSet) map[string]bool {
+	changed := make(map[string]bool)
+	for name, key := range serverFlags {
+		f := flags.Lookup(name)
+		if f == nil {
+			continue
+		}
+		_ = viper.BindPFlag(key, f)
+		if f.Changed {
+			changed[key] = true
+		}
+	}
+	return changed
+}
+
+// commandDefaults returns the default value of the flags of the config commands, by config key
+func commandDefaults() map[string]string {
+	defaults := make(map[string]string)
+	for _, cmd := range configCommands {
+		cmd.Flags().VisitAll(func(f *flag.Flag) {
+			if !skipConfigFlag(f.Name) {
+				defaults[cmd.Name()+"."+f.Name] = f.DefValue
+			}
+		})
+	}
+	return defaults
+}
+
+// registerCommandFlags lets the config file set the flags of cmd in a section named after it
+func registerCommandFlags(cmd *cobra.Command) {
+	configCommands = append(configCommands, cmd)
+	names := make([]string, 0)
+	cmd.Flags().VisitAll(func(f *flag.Flag) {
+		if !skipConfigFlag(f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+	server.RegisterCommandFlags(cmd.Name(), names)
+}
+
+// loadFlags sets the flags of command that are not set on the command line from
+// P2P_<COMMAND>_<FLAG> environment variables, or from the command section of the config file
+func loadFlags(command string, flags *flag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		key := command + "." + f.Name
+		if err != nil || f.Changed || skipConfigFlag(f.Name) || !viper.IsSet(key) {
+			return
+		}
+		value := viper.Get(key)
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, 0, len(list))
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+			value = strings.Join(items, ",")
+		}
+		if e := flags.Set(f.Name, fmt.Sprint(value)); e != nil {
+			err = fmt.Errorf("%s: %v", key, e)
+		}
+	})
+	return err
+}
+
+// loadConfig loads the layered config and the flags of command, and exits on error
+func loadConfig(cmd *cobra.Command) *server.Config {
+	configFile := viper.ConfigFileUsed()
+	config := server.DefaultConfig()
+	if err := config.Load(configFile); err != nil {
+		log.Errorf("Unable to load config: %s:\n%v", configFile, err)
+		os.Exit(1)
+	}
+	if err := loadFlags(cmd.Name(), cmd.Flags()); err != nil {
+		log.Errorf("Unable to load flags: %v", err)
+		os.Exit(1)
+	}
+	return config
+}
+
+func configValidate(cmd *cobra.Command, args []string) {
+	configFile := viper.ConfigFileUsed()
+	config := server.DefaultConfig()
+	err := config.Load(configFile)
+	var invalid *server.ValidationError
+	if errors.As(err, &invalid) {
+		fmt.Printf("Config is invalid, %d problem(s) found\n", len(invalid.Problems))
+		for _, problem := range invalid.Problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Errorf("Unable to load config: %s:\n%v", configFile, err)
+		os.Exit(1)
+	}
+	fmt.Println("Config is valid")
+}
+
+func configShow(cmd *cobra.Command, args []string) {
+	changed := bindServerFlags(cmd.Flags())
+	config := loadConfig(cmd)
+	if configFile := viper.ConfigFileUsed(); configFile != "" {
+		fmt.Printf("# config file: %s\n", configFile)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	settings := append(config.Settings(changed), server.CommandSettings(commandDefaults())...)
+	settings = append(settings, server.DeprecatedSettings()...)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Source)
+	}
+	_ = w.Flush()
+}
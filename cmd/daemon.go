@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg"
+)
+
+// signalContext returns a context canceled when SIGINT or SIGTERM is received
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// runEvery runs task on schedule until SIGINT or SIGTERM is received.
+// Failed runs are logged and retried according to the schedule.
+func runEvery(name string, schedule *pkg.Schedule, task func(ctx context.Context) error) {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	for {
+		err := task(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := schedule.Next(err)
+		if err != nil {
+			log.Errorf("Failed to %s (%d in a row): %v", name, schedule.Failures(), err)
+		}
+		log.Infof("Next %s in %v", name, delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -25,19 +25,34 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/regel/cardano-p2p/log"
 	"github.com/regel/cardano-p2p/pkg"
+	"github.com/regel/cardano-p2p/pkg/client"
+	"github.com/regel/cardano-p2p/pkg/probe"
+	"github.com/regel/cardano-p2p/pkg/sign"
 	"github.com/regel/cardano-p2p/server"
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
-	"github.com/spf13/viper"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	defaultFetchMax   = 10
-	defaultIpVersion  = 4
-	defaultRedisTopic = "p2p"
+	defaultFetchMax     = 10
+	defaultIpVersion    = 4
+	defaultRedisTopic   = "p2p"
+	defaultOverRequest  = 3
+	defaultRandomShare  = 0.2
+	defaultProbeTimeout = 1 * time.Second
+	// maxRequestMax is the largest max accepted by the v1 API
+	maxRequestMax = 20
+	// defaultMaxResponseAge bounds the age of the signed responses of trusted endpoints
+	defaultMaxResponseAge = 5 * time.Minute
+
+	defaultWatchInterval = 1 * time.Hour
+	defaultWatchJitter   = 0.1
+	defaultRetryDelay    = 1 * time.Minute
 )
 
 var fetchCmd = &cobra.Command{
@@ -57,28 +72,71 @@ func newFetchCmd() *cobra.Command {
 }
 
 func addFetchFlags(flags *flag.FlagSet) {
-	flags.String("endpoint-url", defaultEndpoint, heredoc.Doc(`
-The http(s) address used to get a list of Cardano nodes`))
-	flags.Int64("network", testnetMagic, heredoc.Doc(`
-Unique network magic of the Cardano blockchain, eg. 1097911063 for testnet`))
+	flags.StringSlice("endpoint-url", []string{defaultEndpoint}, heredoc.Doc(`
+The http(s) addresses used to get a list of Cardano nodes, queried in parallel`))
+	flags.Int("quorum", 1, heredoc.Doc(`
+Keep only the Cardano nodes returned by at least this number of endpoints`))
+	addNetworkFlag(flags, defaultNetwork)
 	flags.Int64("max", defaultFetchMax, heredoc.Doc(`
 The maximum number of expected Cardano node addresses`))
 	flags.Int64("ipv", defaultIpVersion, heredoc.Doc(`
 The IP protocol version of expected Cardano nodes addresses`))
+	flags.String("verify-endpoint", "", heredoc.Doc(`
+The Ogmios websocket address used to drop Cardano nodes that are not a relay
+registered by an active pool, including the ones kept by --sticky. Custom peers are never dropped`))
+	flags.String("probe", "", heredoc.Doc(`
+Probe candidate Cardano nodes with "tcp" or "handshake" probes and keep the fastest ones`))
+	flags.Int64("over-request", defaultOverRequest, heredoc.Doc(`
+With --probe, request this many times --max candidates from the endpoints, up to 20`))
+	flags.Float64("random-share", defaultRandomShare, heredoc.Doc(`
+With --probe, share of the selected Cardano nodes picked at random instead of by latency`))
+	flags.Duration("probe-timeout", defaultProbeTimeout, heredoc.Doc(`
+With --probe, timeout of each probe`))
+	flags.Float64("sticky", 0, heredoc.Doc(`
+Re-probe the Cardano nodes of the existing --output file and keep the healthy ones,
+up to this fraction of --max. Only the remaining slots are filled with fetched nodes`))
 	flags.String("publish-addr", "", heredoc.Doc(`
 The address of a Redis node to publish topology.json output`))
 	flags.String("topic", defaultRedisTopic, heredoc.Doc(`
-The Redis topic where topology.json output will be published`))
+The Redis topic, or stream, where topology.json output will be published`))
+	flags.String("signing-key", "", heredoc.Doc(`
+PEM Ed25519 private key used to sign the published topology.json output`))
+	flags.StringSlice("trusted-keys", nil, heredoc.Doc(`
+PEM Ed25519 public keys of trusted endpoints. Responses that are not signed
+by one of these keys are refused`))
+	flags.Duration("max-signature-age", defaultMaxResponseAge, heredoc.Doc(`
+With --trusted-keys, responses signed longer than this ago are refused as replayed`))
+	flags.String("publish-mode", publishModePubSub, heredoc.Doc(`
+How topology.json output is published: "pubsub" (PUBLISH) or "stream" (XADD), so that
+subscribers that were down receive the updates they missed`))
 	flags.String("output", "", heredoc.Doc(`
-Write topology.json output to a file`))
+Write topology.json output to a file. The file is replaced atomically, and kept as is
+if no Cardano node could be fetched`))
+	flags.Int("backups", 0, heredoc.Doc(`
+Number of previous --output files to keep as <output>.1, <output>.2, ...`))
 	flags.String("custom-peers", "", heredoc.Doc(`
 *Additional* custom peers to (IP,port[,valency]) to add to your target topology.json
 eg: "10.0.0.1,3001|10.0.0.2,3002|relays.mydomain.com,3003,3"
+In the p2p format, custom peers are written as trustable local roots whose valency
+is the sum of the custom valencies, counting one per IP address.
 `))
+	flags.Bool("watch", false, heredoc.Doc(`
+Keep running and fetch a new topology every --interval. Failed fetches keep the previous
+topology and are retried with an exponential backoff starting at --retry-delay`))
+	flags.Duration("interval", defaultWatchInterval, heredoc.Doc(`
+With --watch, delay between two fetches`))
+	flags.Float64("jitter", defaultWatchJitter, heredoc.Doc(`
+With --watch, delays are randomly shifted by up to this fraction`))
+	flags.Duration("retry-delay", defaultRetryDelay, heredoc.Doc(`
+With --watch, delay before retrying a failed fetch, doubled after each failure up to --interval`))
+	addTopologyFlags(flags)
+	addHookFlags(flags)
 }
 
 func init() {
-	rootCmd.AddCommand(newFetchCmd())
+	cmd := newFetchCmd()
+	registerCommandFlags(cmd)
+	rootCmd.AddCommand(cmd)
 }
 
 func decodeProducers(arg string) []pkg.Producer {
@@ -105,80 +163,285 @@ func decodeProducers(arg string) []pkg.Producer {
 	return out
 }
 
-func printResult(out string, filename string) {
-	var f *os.File
-	var err error
-	outputToFile := filename != ""
-	if !outputToFile {
-		f = os.Stdout
-	} else {
-		f, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Errorf("Error opening file: '%s'", filename)
-			panic(err)
+// printResult writes out to stdout, or atomically replaces filename
+// keeping up to backups previous versions
+func printResult(out string, filename string, backups int) error {
+	if filename == "" {
+		writer := bufio.NewWriter(os.Stdout)
+		if _, err := writer.WriteString(out); err != nil {
+			return err
 		}
-		defer f.Close()
+		return writer.Flush()
 	}
-	writer := bufio.NewWriter(f)
-	_, err = writer.WriteString(out)
-	if err != nil {
-		fmt.Println("Error writing to buffer")
-		panic(err)
+	return pkg.WriteFileAtomic(filename, []byte(out), backups)
+}
+
+func newProber(mode string, magic int64) (probe.Prober, error) {
+	switch mode {
+	case server.ProbeModeTCP:
+		return probe.New(), nil
+	case server.ProbeModeHandshake:
+		return probe.NewHandshake(uint64(magic)), nil
+	default:
+		return nil, fmt.Errorf("unknown probe '%s'", mode)
 	}
-	writer.Flush()
 }
 
-func fetch(cmd *cobra.Command, args []string) {
-	configFile := viper.ConfigFileUsed()
-	config := server.DefaultConfig()
-	if err := config.Load(configFile); err != nil {
-		log.Errorf("Unable to load config: %s:\n%v", configFile, err)
-		os.Exit(1)
+// keepPreviousProducers re-probes the producers of a previous topology file
+// and returns up to max healthy ones, in their previous order
+func keepPreviousProducers(filename string, prober probe.Prober, timeout time.Duration, custom []pkg.Producer, max int) []pkg.Producer {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Cannot read previous topology '%s': %v", filename, err)
+		}
+		return nil
 	}
-	b, _ := json.Marshal(config)
-	log.Debugf("Config: \n%v", string(b))
-	context := context.Background()
+	previous, err := pkg.ParseTopologyProducers(data)
+	if err != nil {
+		log.Warnf("Cannot parse previous topology '%s': %v", filename, err)
+		return nil
+	}
+	previous = pkg.ExcludeProducers(previous, custom)
+	probed := pkg.ProbeProducers(previous, prober, timeout)
+	for _, p := range probed {
+		if p.Err != nil {
+			log.Infof("Not keeping '%s:%d': %v", p.Addr, p.Port, p.Err)
+		}
+	}
+	kept := pkg.KeepHealthy(probed, max)
+	log.Infof("Keeping %d of %d previous producers", len(kept), len(previous))
+	return kept
+}
 
-	endpointUrl, _ := cmd.Flags().GetString("endpoint-url")
-	magic, _ := cmd.Flags().GetInt64("network")
-	max, _ := cmd.Flags().GetInt64("max")
-	ipv, _ := cmd.Flags().GetInt64("ipv")
-	customPeers, _ := cmd.Flags().GetString("custom-peers")
+// fetcher holds the validated options of the fetch command
+type fetcher struct {
+	flags          *flag.FlagSet
+	endpoints      []string
+	quorum         int
+	magic          int64
+	max            int64
+	requestMax     int64
+	ipv            int64
+	custom         []pkg.Producer
+	verifyEndpoint string
+	prober         probe.Prober
+	stickyProber   probe.Prober
+	sticky         float64
+	randomShare    float64
+	timeout        time.Duration
+	output         string
+	backups        int
+	publishAddr    string
+	publishMode    string
+	signer         *sign.Signer
+	options        []client.Option
+	topic          string
+	hook           *pkg.Hook
+}
 
-	src, err := pkg.Fetch(context, endpointUrl, magic, max, ipv)
+func newFetcher(config *server.Config, flags *flag.FlagSet) (*fetcher, error) {
+	var err error
+	f := &fetcher{flags: flags}
+	network, err := resolveNetwork(flags, config)
 	if err != nil {
-		log.Errorf("Unable to get data: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
-	var payload pkg.PullPayload
-	err = json.Unmarshal(src, &payload)
-	if err != nil {
-		log.Errorf("Unmarshal error: %v\n", err)
-		os.Exit(1)
+	f.endpoints = presetEndpoints(flags, network)
+	f.quorum, _ = flags.GetInt("quorum")
+	f.magic = int64(network.NetworkMagic)
+	f.max, _ = flags.GetInt64("max")
+	f.ipv, _ = flags.GetInt64("ipv")
+	f.verifyEndpoint, _ = flags.GetString("verify-endpoint")
+	f.sticky, _ = flags.GetFloat64("sticky")
+	f.randomShare, _ = flags.GetFloat64("random-share")
+	f.timeout, _ = flags.GetDuration("probe-timeout")
+	f.output, _ = flags.GetString("output")
+	f.backups, _ = flags.GetInt("backups")
+	f.publishAddr, _ = flags.GetString("publish-addr")
+	f.topic, _ = flags.GetString("topic")
+	f.publishMode, _ = flags.GetString("publish-mode")
+	if f.publishMode != publishModePubSub && f.publishMode != publishModeStream {
+		return nil, fmt.Errorf("unknown publish mode '%s'", f.publishMode)
+	}
+	if customPeers, _ := flags.GetString("custom-peers"); customPeers != "" {
+		f.custom = decodeProducers(customPeers)
+	}
+	if signingKey, _ := flags.GetString("signing-key"); signingKey != "" {
+		if f.signer, err = sign.LoadSigner(signingKey); err != nil {
+			return nil, err
+		}
+	}
+	if trustedKeys, _ := flags.GetStringSlice("trusted-keys"); len(trustedKeys) > 0 {
+		verifier, err := sign.LoadVerifier(trustedKeys...)
+		if err != nil {
+			return nil, err
+		}
+		verifier.MaxAge, _ = flags.GetDuration("max-signature-age")
+		f.options = append(f.options, client.WithVerifier(verifier))
+	}
+	if f.hook, err = newHook(flags); err != nil {
+		return nil, fmt.Errorf("invalid reload hook: %v", err)
+	}
+	if f.quorum < 1 || f.quorum > len(f.endpoints) {
+		return nil, fmt.Errorf("quorum must be between 1 and the number of endpoints (%d)", len(f.endpoints))
+	}
+	if f.sticky < 0 || f.sticky > 1 {
+		return nil, fmt.Errorf("sticky fraction must be between 0 and 1")
+	}
+	f.requestMax = f.max
+	if probeMode, _ := flags.GetString("probe"); probeMode != "" {
+		if f.prober, err = newProber(probeMode, f.magic); err != nil {
+			return nil, err
+		}
+		if f.randomShare < 0 || f.randomShare > 1 {
+			return nil, fmt.Errorf("random share must be between 0 and 1")
+		}
+		overRequest, _ := flags.GetInt64("over-request")
+		if overRequest < 1 {
+			return nil, fmt.Errorf("over request factor must be at least 1")
+		}
+		f.requestMax = f.max * overRequest
+		if f.requestMax > maxRequestMax {
+			f.requestMax = maxRequestMax
+		}
 	}
-	if customPeers != "" {
-		peers := decodeProducers(customPeers)
-		payload.Producers = append(payload.Producers, peers...)
+	if f.sticky > 0 && f.output != "" {
+		f.stickyProber = f.prober
+		if f.stickyProber == nil {
+			f.stickyProber = probe.New()
+		}
 	}
-	dst := &bytes.Buffer{}
-	data, _ := json.Marshal(payload)
-	if err := json.Indent(dst, data, "", "  "); err != nil {
-		panic(err)
+	return f, nil
+}
+
+// fetch writes and publishes a new topology. The previous topology is kept on failure.
+func (f *fetcher) fetch(ctx context.Context) error {
+	var kept []pkg.Producer
+	if f.stickyProber != nil {
+		kept = keepPreviousProducers(f.output, f.stickyProber, f.timeout, f.custom, int(float64(f.max)*f.sticky))
 	}
 
-	fname, _ := cmd.Flags().GetString("output")
-	printResult(dst.String(), fname)
+	payloads := make([]*pkg.PullPayload, 0, len(f.endpoints))
+	for _, result := range pkg.FetchAll(ctx, f.endpoints, f.magic, f.requestMax, f.ipv, f.options...) {
+		if result.Err != nil {
+			log.Errorf("Unable to get data from '%s': %v", result.Endpoint, result.Err)
+			continue
+		}
+		payloads = append(payloads, result.Payload)
+	}
+	if len(payloads) < f.quorum {
+		return fmt.Errorf("only %d of %d endpoints answered, quorum is %d", len(payloads), len(f.endpoints), f.quorum)
+	}
+	payload := *payloads[0]
+	payload.Producers = pkg.ExcludeProducers(pkg.MergeProducers(payloads, f.quorum), f.custom)
+	if len(payload.Producers) == 0 {
+		return fmt.Errorf("no producer fetched, keeping the previous topology")
+	}
+	payload.Producers = pkg.ExcludeProducers(payload.Producers, kept)
+	if f.verifyEndpoint != "" {
+		// the kept producers are verified along with the fetched ones
+		candidates := append(append([]pkg.Producer{}, kept...), payload.Producers...)
+		verified, dropped, err := pkg.VerifyProducers(ctx, pkg.NewOgmiosPoolSource(f.verifyEndpoint), candidates)
+		if err != nil {
+			return fmt.Errorf("unable to verify producers: %v", err)
+		}
+		for _, p := range dropped {
+			log.Warnf("Dropping '%s:%d': not a registered pool relay", p.Addr, p.Port)
+		}
+		kept = pkg.ExcludeProducers(kept, dropped)
+		payload.Producers = pkg.ExcludeProducers(verified, kept)
+	}
+	slots := f.max - int64(len(kept))
+	if f.prober != nil {
+		probed := pkg.ProbeProducers(payload.Producers, f.prober, f.timeout)
+		for _, p := range probed {
+			if p.Err != nil {
+				log.Infof("Dropping '%s:%d': %v", p.Addr, p.Port, p.Err)
+			}
+		}
+		payload.Producers = pkg.SelectProducers(probed, int(slots), f.randomShare)
+	}
+	if int64(len(payload.Producers)) > slots {
+		payload.Producers = payload.Producers[:slots]
+	}
+	payload.Producers = append(kept, payload.Producers...)
+	if len(payload.Producers) == 0 {
+		return fmt.Errorf("no producer left, keeping the previous topology")
+	}
+	out, err := renderTopology(f.flags, payload, f.custom)
+	if err != nil {
+		return fmt.Errorf("cannot render topology: %v", err)
+	}
+	if err := pkg.ValidateTopology(out); err != nil {
+		return fmt.Errorf("invalid topology, keeping the previous one: %v", err)
+	}
+	dst := bytes.NewBuffer(out)
 
-	publishAddr, _ := cmd.Flags().GetString("publish-addr")
-	topic, _ := cmd.Flags().GetString("topic")
-	if publishAddr != "" && topic != "" {
+	if f.output != "" && !pkg.TopologyChanged(f.output, out) {
+		log.Infof("Topology '%s' is unchanged", f.output)
+	} else {
+		if err := printResult(dst.String(), f.output, f.backups); err != nil {
+			return fmt.Errorf("cannot write topology: %v", err)
+		}
+		if f.output != "" {
+			if err := f.hook.Trigger(); err != nil {
+				log.Errorf("Reload hook failed: %v", err)
+			}
+		}
+	}
+
+	if f.publishAddr != "" && f.topic != "" {
+		published := out
+		if f.signer != nil {
+			published = f.signer.Seal(sign.TopicScope(f.topic), out)
+		}
 		redisClient := redis.NewClient(&redis.Options{
-			Addr:     publishAddr,
+			Addr:     f.publishAddr,
 			Username: os.Getenv("REDIS_USER"),
 			Password: os.Getenv("REDISCLI_AUTH"),
 		})
-		if err := redisClient.Publish(context, topic, dst.String()).Err(); err != nil {
-			panic(err)
+		defer redisClient.Close()
+		if f.publishMode == publishModeStream {
+			version, err := pkg.NewTopologyStream(redisClient, f.topic).Publish(ctx, published)
+			if err != nil {
+				return fmt.Errorf("cannot publish topology: %v", err)
+			}
+			log.Infof("Published topology version %s", version)
+		} else if err := redisClient.Publish(ctx, f.topic, string(published)).Err(); err != nil {
+			return fmt.Errorf("cannot publish topology: %v", err)
+		}
+	}
+	return nil
+}
+
+func fetch(cmd *cobra.Command, args []string) {
+	config := loadConfig(cmd)
+	b, _ := json.Marshal(config)
+	log.Debugf("Config: \n%v", string(b))
+
+	f, err := newFetcher(config, cmd.Flags())
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+	if watch, _ := cmd.Flags().GetBool("watch"); watch {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		jitter, _ := cmd.Flags().GetFloat64("jitter")
+		retryDelay, _ := cmd.Flags().GetDuration("retry-delay")
+		if interval <= 0 || retryDelay <= 0 || jitter < 0 || jitter >= 1 {
+			log.Errorf("Interval and retry delay must be positive, and jitter between 0 and 1")
+			os.Exit(1)
 		}
+		runEvery("fetch", &pkg.Schedule{
+			Interval:   interval,
+			Jitter:     jitter,
+			RetryDelay: retryDelay,
+		}, f.fetch)
+		return
+	}
+	if err := f.fetch(context.Background()); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/server"
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+const fetchTestResponse = `{
+  "resultcode": "201",
+  "datetime": "%s",
+  "clientIp": "127.0.0.1",
+  "iptype": 4,
+  "msg": "nice to meet you",
+  "Producers": [%s]
+}`
+
+// newTestFetcher returns a fetcher of endpoint with the given flags
+func newTestFetcher(t *testing.T, endpoint string, args ...string) *fetcher {
+	flags := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	addFetchFlags(flags)
+	require.NoError(t, flags.Parse(append([]string{"--endpoint-url", endpoint}, args...)))
+	f, err := newFetcher(server.DefaultConfig(), flags)
+	require.NoError(t, err)
+	return f
+}
+
+func TestFetchEmptyKeepsPrevious(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, fetchTestResponse, "2022-02-05 16:54:21", "")
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "topology.json")
+	previous := []byte(`{"Producers":[{"addr":"10.0.0.3","port":3001,"valency":1}]}`)
+	require.NoError(t, ioutil.WriteFile(output, previous, 0644))
+	runs := filepath.Join(t.TempDir(), "runs")
+
+	f := newTestFetcher(t, ts.URL, "--output", output, "--custom-peers", "10.0.0.1,3001",
+		"--reload-command", "echo run >> "+runs)
+	require.Error(t, f.fetch(context.Background()))
+
+	data, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, previous, data)
+	require.NoFileExists(t, runs)
+}
+
+func TestFetchUnchangedSkipsHook(t *testing.T) {
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		datetime := time.Date(2022, 2, 5, 16, fetches, 0, 0, time.UTC).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(w, fetchTestResponse, datetime, `{"addr":"10.0.0.2","port":3001,"valency":1}`)
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "topology.json")
+	runs := filepath.Join(t.TempDir(), "runs")
+	f := newTestFetcher(t, ts.URL, "--output", output, "--reload-command", "echo run >> "+runs,
+		"--reload-debounce", "0")
+	require.NoError(t, f.fetch(context.Background()))
+	first, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	require.NoError(t, f.fetch(context.Background()))
+	require.Equal(t, 2, fetches)
+
+	data, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, first, data)
+	data, err = ioutil.ReadFile(runs)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "run"))
+}
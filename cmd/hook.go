@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/regel/cardano-p2p/pkg"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	defaultReloadSignal   = "HUP"
+	defaultReloadDebounce = 30 * time.Second
+	defaultReloadTimeout  = 1 * time.Minute
+)
+
+func addHookFlags(flags *flag.FlagSet) {
+	flags.String("reload-pidfile", "", heredoc.Doc(`
+Send --reload-signal to the process whose PID is in this file when the topology changed,
+eg. cardano-node in P2P mode reloads its topology on SIGHUP`))
+	flags.String("reload-signal", defaultReloadSignal, heredoc.Doc(`
+Signal sent to the process of --reload-pidfile`))
+	flags.String("reload-command", "", heredoc.Doc(`
+Shell command run when the topology changed, eg. "systemctl restart cardano-node"`))
+	flags.Duration("reload-debounce", defaultReloadDebounce, heredoc.Doc(`
+Minimum delay between two reloads. Changes written sooner are reloaded once at the end of the delay`))
+	flags.Duration("reload-timeout", defaultReloadTimeout, heredoc.Doc(`
+Kill --reload-command if it is still running after this delay, 0 waits forever`))
+}
+
+func newHook(flags *flag.FlagSet) (*pkg.Hook, error) {
+	pidFile, _ := flags.GetString("reload-pidfile")
+	signal, _ := flags.GetString("reload-signal")
+	command, _ := flags.GetString("reload-command")
+	debounce, _ := flags.GetDuration("reload-debounce")
+	timeout, _ := flags.GetDuration("reload-timeout")
+	return pkg.NewHook(pidFile, signal, command, debounce, timeout)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2021 Sebastien Leger
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg/sign"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generates an Ed25519 key pair used to sign topologies",
+	Long: `
+The keygen command writes a PEM encoded Ed25519 private key, used by fetch --signing-key and
+the server signing-key setting, and the matching public key, given to fetch and subscribe
+--trusted-keys.`,
+	Run: keygen,
+}
+
+func newKeygenCmd() *cobra.Command {
+	cmd := keygenCmd
+	flags := cmd.Flags()
+	addKeygenFlags(flags)
+	cobra.CheckErr(cmd.MarkFlagRequired("private-key"))
+	cobra.CheckErr(cmd.MarkFlagRequired("public-key"))
+	return cmd
+}
+
+func addKeygenFlags(flags *flag.FlagSet) {
+	flags.String("private-key", "", heredoc.Doc(`
+Write the private key to this file`))
+	flags.String("public-key", "", heredoc.Doc(`
+Write the public key to this file`))
+}
+
+func init() {
+	rootCmd.AddCommand(newKeygenCmd())
+}
+
+func keygen(cmd *cobra.Command, args []string) {
+	privateKey, _ := cmd.Flags().GetString("private-key")
+	publicKey, _ := cmd.Flags().GetString("public-key")
+	privPem, pubPem, err := sign.GenerateKey()
+	if err != nil {
+		log.Errorf("Cannot generate key: %v", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(privateKey); err == nil {
+		log.Errorf("Private key '%s' already exists", privateKey)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(privateKey, privPem, 0600); err != nil {
+		log.Errorf("Cannot write private key: %v", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(publicKey, pubPem, 0644); err != nil {
+		log.Errorf("Cannot write public key: %v", err)
+		os.Exit(1)
+	}
+	priv, _ := sign.ParsePrivateKey(privPem)
+	log.Infof("Generated key %s", sign.NewSigner(priv).KeyID())
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/regel/cardano-p2p/server"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func addNetworkFlag(flags *flag.FlagSet, value string) {
+	usage := heredoc.Doc(`
+Cardano network: "mainnet", "preprod", "preview", "sanchonet", a preset from the
+network-presets section of the config file, or a network magic, eg. 764824073`)
+	if value != "" {
+		usage += "\nDefaults to server.network or server.magic when they are set"
+	}
+	flags.String("network", value, usage)
+}
+
+// networkPresets returns the custom presets of the config file
+func networkPresets() ([]server.NetworkPreset, error) {
+	var presets []server.NetworkPreset
+	if err := viper.UnmarshalKey("network-presets", &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// resolveNetwork returns the preset selected with --network, or the network
+// of the server section of config when --network is set neither on the command line
+// nor in the command section
+func resolveNetwork(flags *flag.FlagSet, config *server.Config) (server.NetworkPreset, error) {
+	presets, err := networkPresets()
+	if err != nil {
+		return server.NetworkPreset{}, err
+	}
+	network, _ := flags.GetString("network")
+	if !flags.Changed("network") {
+		network = strconv.FormatUint(config.Server.NetworkMagic, 10)
+	}
+	return server.LookupNetwork(network, presets)
+}
+
+// presetEndpoints returns the endpoints of the network preset,
+// unless --endpoint-url is set
+func presetEndpoints(flags *flag.FlagSet, network server.NetworkPreset) []string {
+	endpoints, _ := flags.GetStringSlice("endpoint-url")
+	if !flags.Changed("endpoint-url") && len(network.Endpoints) > 0 {
+		return network.Endpoints
+	}
+	return endpoints
+}
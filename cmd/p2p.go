@@ -16,14 +16,13 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"os"
 
 	"encoding/json"
 	"github.com/regel/cardano-p2p/log"
 	"github.com/regel/cardano-p2p/pkg"
-	"github.com/regel/cardano-p2p/server"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var p2pCmd = &cobra.Command{
@@ -37,23 +36,36 @@ to the p2p service`,
 }
 
 func init() {
+	flags := p2pCmd.Flags()
+	addNetworkFlag(flags, "")
 	rootCmd.AddCommand(p2pCmd)
 }
 
 func p2p(cmd *cobra.Command, args []string) {
-	var ch chan pkg.Producer
-	configFile := viper.ConfigFileUsed()
-	config := server.DefaultConfig()
-	if err := config.Load(configFile); err != nil {
-		log.Errorf("Unable to load config: %s:\n%v", configFile, err)
-		os.Exit(1)
-	}
+	bindServerFlags(cmd.Flags())
+	config := loadConfig(cmd)
 	b, _ := json.Marshal(config)
 	log.Debugf("Config: \n%v", string(b))
-	ch = make(chan pkg.Producer, config.Client.FetchMaximum)
-	if config.Client.Enabled {
-		go pkg.Push(&config.Client, ch)
+	shutdownTracing, err := pkg.InitTracing(context.Background(), &config.Tracing)
+	if err != nil {
+		log.Errorf("Unable to init tracing: %v", err)
+		os.Exit(1)
+	}
+	var gossip *pkg.Gossip
+	if config.Server.Gossip.Enabled {
+		gossipConfig := &config.Server.Gossip
+		gossip = pkg.NewGossip(pkg.GossipServerId(gossipConfig), gossipConfig.Quorum, gossipConfig.MaxAge)
+		go pkg.RunGossip(gossipConfig, gossip)
+	}
+	networks := make(pkg.Networks, 0)
+	for _, networkConfig := range config.NetworkConfigs() {
+		network := pkg.NewNetwork(networkConfig)
+		networks = append(networks, network)
+		log.Infof("serving network magic %d", network.Magic())
+		if networkConfig.Client.Enabled {
+			go pkg.Push(networkConfig, network.Producers, network.Peers, network.Pools, gossip)
+		}
 	}
-	pkg.Serve(&config.Server, ch)
+	pkg.Serve(&config.Server, networks, gossip, shutdownTracing)
 	select {} // infinite loop
 }
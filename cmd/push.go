@@ -21,14 +21,22 @@ import (
 	"fmt"
 	"github.com/MakeNowJust/heredoc"
 	"os"
+	"time"
 
 	"encoding/json"
 	"github.com/regel/cardano-p2p/log"
 	"github.com/regel/cardano-p2p/pkg"
-	"github.com/regel/cardano-p2p/server"
+	"github.com/regel/cardano-p2p/pkg/client"
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
-	"github.com/spf13/viper"
+)
+
+const (
+	defaultPushRetries    = 2
+	defaultPushRetryDelay = 10 * time.Second
+	defaultPrometheusUrl  = "http://localhost:12798/metrics"
+	defaultEKGUrl         = "http://localhost:12788"
+	tipReportTimeout      = 5 * time.Second
 )
 
 var pushCmd = &cobra.Command{
@@ -49,46 +57,204 @@ func newPushCmd() *cobra.Command {
 }
 
 func addPushFlags(flags *flag.FlagSet) {
-	flags.String("endpoint-url", defaultEndpoint, heredoc.Doc(`
-The http(s) address used to get a list of Cardano nodes`))
-	flags.Int64("network", testnetMagic, heredoc.Doc(`
-Unique network magic of the Cardano blockchain, eg. 1097911063 for testnet`))
+	flags.StringSlice("endpoint-url", []string{defaultEndpoint}, heredoc.Doc(`
+The http(s) addresses where our ledger tip is pushed, in parallel, eg. api.clio.one and our own p2p servers`))
+	addNetworkFlag(flags, defaultNetwork)
 	flags.Int64("port", defaultNodePort, heredoc.Doc(`
 Public port number of the Cardano node`))
+	flags.String("tip-source", pkg.TipSourceOgmios, heredoc.Doc(`
+Where the blockNo of our Cardano node is read: "ogmios", "prometheus" or "ekg"`))
+	flags.String("tip-url", "", heredoc.Doc(`
+Address of --tip-source. Defaults to client.endpoint from the config file for "ogmios",
+http://localhost:12798/metrics for "prometheus" and http://localhost:12788 for "ekg"`))
+	flags.Int("retries", defaultPushRetries, heredoc.Doc(`
+Number of retries of a push that failed or was answered with an error result code`))
+	flags.Duration("retry-delay", defaultPushRetryDelay, heredoc.Doc(`
+Delay between two attempts to push to the same endpoint`))
+	flags.Duration("interval", 0, heredoc.Doc(`
+Keep running and push a new ledger tip every interval, eg. 1h. Endpoints that failed are
+retried alone, the others are pushed again once the interval elapsed.
+The default pushes once, prints the answer of each endpoint and exits`))
+	flags.Float64("jitter", defaultWatchJitter, heredoc.Doc(`
+With --interval, delays are randomly shifted by up to this fraction`))
+	flags.StringSlice("tip-report-url", nil, heredoc.Doc(`
+Addresses of our own p2p servers where our ledger tip is also reported through /api/v2/tips,
+so that /api/v2/peers shows the tip lag of this relay`))
+	flags.String("tip-report-token", "", heredoc.Doc(`
+Bearer token sent to --tip-report-url, the server.tip-token of our p2p servers`))
+	flags.String("status-file", "", heredoc.Doc(`
+Write the status of the last push to each endpoint to this file, as JSON`))
 }
 
 func init() {
-	rootCmd.AddCommand(newPushCmd())
+	cmd := newPushCmd()
+	registerCommandFlags(cmd)
+	rootCmd.AddCommand(cmd)
 }
 
-func push(cmd *cobra.Command, args []string) {
-	configFile := viper.ConfigFileUsed()
-	config := server.DefaultConfig()
-	if err := config.Load(configFile); err != nil {
-		log.Errorf("Unable to load config: %s:\n%v", configFile, err)
-		os.Exit(1)
+// pusher pushes our ledger tip to all endpoints and tracks their status
+type pusher struct {
+	tip        pkg.TipProvider
+	endpoints  []string
+	magic      int64
+	port       int64
+	retries    int
+	retryDelay time.Duration
+	statusFile string
+	status     []pkg.PushStatus
+	tipReports []string
+	tipToken   string
+	// minInterval is the shortest delay between two scheduled pushes,
+	// zero when pushing once
+	minInterval time.Duration
+	results     []pkg.PushResult
+}
+
+// due returns the indexes of the endpoints to push: the ones that were never pushed
+// or failed, and the ones whose last success is at least minInterval old
+func (p *pusher) due(now time.Time) []int {
+	out := make([]int, 0, len(p.status))
+	for i, s := range p.status {
+		if s.LastSuccess == nil || s.ConsecutiveFailures > 0 || now.Sub(*s.LastSuccess) >= p.minInterval {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// reportTip reports blockNo to the v2 API of our own p2p servers
+func (p *pusher) reportTip(ctx context.Context, blockNo int64) {
+	for _, endpoint := range p.tipReports {
+		c, err := client.New(endpoint, client.WithToken(p.tipToken))
+		if err == nil {
+			reportCtx, cancel := context.WithTimeout(ctx, tipReportTimeout)
+			err = c.ReportTip(reportCtx, p.magic, p.port, blockNo)
+			cancel()
+		}
+		if err != nil {
+			log.Errorf("Cannot report blockNo %d to '%s': %v", blockNo, endpoint, err)
+		}
+	}
+}
+
+func (p *pusher) push(ctx context.Context) error {
+	blockNo, err := p.tip.BlockNo(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get blockNo: %v", err)
+	}
+	now := time.Now()
+	due := p.due(now)
+	endpoints := make([]string, len(due))
+	for i, j := range due {
+		endpoints[i] = p.endpoints[j]
+	}
+	failed := 0
+	p.results = pkg.PushAll(ctx, endpoints, p.magic, p.port, blockNo, p.retries, p.retryDelay)
+	for i, result := range p.results {
+		p.status[due[i]].Update(result, blockNo, now)
+		if result.Err != nil {
+			failed++
+			log.Errorf("Cannot push blockNo %d to '%s' after %d attempts: %v", blockNo, result.Endpoint, result.Attempts, result.Err)
+		} else {
+			log.Infof("Pushed blockNo %d to '%s': %s %s", blockNo, result.Endpoint, result.Payload.ResultCode, result.Payload.Msg)
+		}
+	}
+	p.reportTip(ctx, blockNo)
+	if p.statusFile != "" {
+		data, _ := json.MarshalIndent(p.status, "", "  ")
+		if err := pkg.WriteFileAtomic(p.statusFile, data, 0); err != nil {
+			log.Errorf("Cannot write status file: %v", err)
+		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d endpoints failed", failed, len(endpoints))
+	}
+	return nil
+}
+
+func push(cmd *cobra.Command, args []string) {
+	config := loadConfig(cmd)
 	b, _ := json.Marshal(config)
 	log.Debugf("Config: \n%v", string(b))
-	context := context.Background()
 
-	endpointUrl, _ := cmd.Flags().GetString("endpoint-url")
-	magic, _ := cmd.Flags().GetInt64("network")
-	port, _ := cmd.Flags().GetInt64("port")
-
-	blockNo, err := pkg.GetBlockHeight(config.Client.Endpoint)
+	tipSource, _ := cmd.Flags().GetString("tip-source")
+	tipUrl, _ := cmd.Flags().GetString("tip-url")
+	if tipUrl == "" {
+		switch tipSource {
+		case pkg.TipSourceOgmios:
+			tipUrl = config.Client.Endpoint
+		case pkg.TipSourcePrometheus:
+			tipUrl = defaultPrometheusUrl
+		case pkg.TipSourceEKG:
+			tipUrl = defaultEKGUrl
+		}
+	}
+	tip, err := pkg.NewTipProvider(tipSource, tipUrl)
 	if err != nil {
-		log.Errorf("Cannot get blockNo: %v", err)
+		log.Errorf("%v", err)
 		os.Exit(1)
 	}
-	src, err := pkg.PushBlockNo(context, endpointUrl, magic, port, *blockNo)
+	network, err := resolveNetwork(cmd.Flags(), config)
 	if err != nil {
-		log.Errorf("Cannot push ledger data: %v", err)
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+	p := &pusher{tip: tip}
+	p.endpoints = presetEndpoints(cmd.Flags(), network)
+	p.magic = int64(network.NetworkMagic)
+	p.port, _ = cmd.Flags().GetInt64("port")
+	p.retries, _ = cmd.Flags().GetInt("retries")
+	p.retryDelay, _ = cmd.Flags().GetDuration("retry-delay")
+	p.statusFile, _ = cmd.Flags().GetString("status-file")
+	p.tipReports, _ = cmd.Flags().GetStringSlice("tip-report-url")
+	p.tipToken, _ = cmd.Flags().GetString("tip-report-token")
+	p.status = make([]pkg.PushStatus, len(p.endpoints))
+	for i, endpoint := range p.endpoints {
+		p.status[i].Endpoint = endpoint
+	}
+	if len(p.endpoints) == 0 || p.retries < 0 {
+		log.Errorf("At least one endpoint is required, and retries must not be negative")
+		os.Exit(1)
+	}
+
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if interval > 0 {
+		jitter, _ := cmd.Flags().GetFloat64("jitter")
+		if jitter < 0 || jitter >= 1 {
+			log.Errorf("Jitter must be between 0 and 1")
+			os.Exit(1)
+		}
+		// failed endpoints are retried alone, the others wait for the interval
+		p.minInterval = time.Duration((1 - jitter) * float64(interval))
+		runEvery("push", &pkg.Schedule{
+			Interval:   interval,
+			Jitter:     jitter,
+			RetryDelay: defaultRetryDelay,
+		}, p.push)
+		return
+	}
+	if err := p.push(context.Background()); err != nil {
+		log.Errorf("Push failed: %v", err)
+	}
+	if len(p.results) == 0 {
+		// the ledger tip is unknown
 		os.Exit(1)
 	}
-	dst := &bytes.Buffer{}
-	if err := json.Indent(dst, src, "", "  "); err != nil {
-		panic(err)
+	unreachable := false
+	for _, result := range p.results {
+		// error result codes are printed, as answered by the endpoint
+		if result.Payload == nil {
+			unreachable = true
+			continue
+		}
+		src, _ := json.Marshal(result.Payload)
+		dst := &bytes.Buffer{}
+		if err := json.Indent(dst, src, "", "  "); err != nil {
+			panic(err)
+		}
+		fmt.Println(dst.String())
+	}
+	if unreachable {
+		os.Exit(1)
 	}
-	fmt.Println(dst.String())
 }
@@ -17,10 +17,13 @@ package cmd
 
 import (
 	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg/client"
+	"github.com/regel/cardano-p2p/server"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -97,6 +100,7 @@ func initConfig() {
 	}
 
 	log.Infof("cardano-p2p version %s %s [%s]\n", version, date, commit)
+	client.Version = version
 
 	if cfgFile != "" {
 		// Use config file from the flag.
@@ -112,11 +116,13 @@ func initConfig() {
 		viper.SetConfigName(".cardano-p2p")
 	}
 
-	viper.SetEnvPrefix("P2P")
+	viper.SetEnvPrefix(server.EnvPrefix)
+	// nested keys are read from P2P_SERVER_MAX_PEERS for server.max-peers
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		log.Infof("Using config file:", viper.ConfigFileUsed())
+		log.Infof("Using config file: %s", viper.ConfigFileUsed())
 	}
 }
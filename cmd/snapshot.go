@@ -0,0 +1,98 @@
+/*
+Copyright © 2021 Sebastien Leger
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Produces a Genesis peer-snapshot.json file from vetted pools",
+	Long: `
+The snapshot command connects to Ogmios, vets every registered pool and writes the big ledger
+pools, the pools with the largest stake making up the given cumulative stake share, along with
+their relays to a peer-snapshot.json file used by Ouroboros Genesis nodes.`,
+	Run: snapshot,
+}
+
+func newSnapshotCmd() *cobra.Command {
+	cmd := snapshotCmd
+	flags := cmd.Flags()
+	addSnapshotFlags(flags)
+	return cmd
+}
+
+func addSnapshotFlags(flags *flag.FlagSet) {
+	flags.String("endpoint", "", heredoc.Doc(`
+The Ogmios websocket address, defaults to the client endpoint of the config file`))
+	flags.Float64("stake-percent", 0, heredoc.Doc(`
+Cumulative stake percentage of the big ledger pools, defaults to the server snapshot-stake-percent of the config file`))
+	flags.String("output", "", heredoc.Doc(`
+Write peer-snapshot.json output to a file`))
+}
+
+func init() {
+	cmd := newSnapshotCmd()
+	registerCommandFlags(cmd)
+	rootCmd.AddCommand(cmd)
+}
+
+func snapshot(cmd *cobra.Command, args []string) {
+	config := loadConfig(cmd)
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	if endpoint == "" {
+		endpoint = config.Client.Endpoint
+	}
+	stakePercent, _ := cmd.Flags().GetFloat64("stake-percent")
+	if stakePercent == 0 {
+		stakePercent = config.Server.SnapshotStakePercent
+	}
+	if stakePercent < 0 || stakePercent > 100 {
+		log.Errorf("Invalid stake percent: %v", stakePercent)
+		os.Exit(1)
+	}
+
+	chainTip, err := pkg.GetChainTip(endpoint)
+	if err != nil {
+		log.Errorf("Cannot get chain tip: %v", err)
+		os.Exit(1)
+	}
+	reports, err := pkg.VetPools(context.Background(), endpoint)
+	if err != nil {
+		log.Errorf("Cannot vet pools: %v", err)
+		os.Exit(1)
+	}
+	dst := &bytes.Buffer{}
+	data, _ := json.Marshal(pkg.NewPeerSnapshot(reports, chainTip.Slot, stakePercent))
+	if err := json.Indent(dst, data, "", "  "); err != nil {
+		panic(err)
+	}
+	fname, _ := cmd.Flags().GetString("output")
+	if err := printResult(dst.String(), fname, 0); err != nil {
+		log.Errorf("Cannot write snapshot: %v", err)
+		os.Exit(1)
+	}
+}
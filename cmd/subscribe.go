@@ -18,21 +18,36 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg"
+	"github.com/regel/cardano-p2p/pkg/sign"
 	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
 )
 
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 1 * time.Minute
+	// defaultMaxTopologyAge bounds the age of signed topologies, eg. the latest entry of a stream
+	defaultMaxTopologyAge = 24 * time.Hour
+)
+
 var (
 	Addr     string
 	Password string
 	Topic    string
 	Output   string
+	Mode     string
+	Group    string
+	Consumer string
 )
 
 // subscribeCmd represents the subscribe command
@@ -49,10 +64,16 @@ func init() {
 	subscribeCmd.Flags().StringVarP(&Password, "password", "p", "", "Redis password")
 	subscribeCmd.Flags().StringVarP(&Topic, "topic", "t", "cardano", "Topic to subscribe to")
 	subscribeCmd.Flags().StringVarP(&Output, "output", "o", "", "Output file path")
-	err := subscribeCmd.MarkFlagRequired("output")
-	if err != nil {
-		panic("failed to configure flags")
-	}
+	subscribeCmd.Flags().StringVar(&Mode, "mode", publishModePubSub, `How topologies are received: "pubsub" (SUBSCRIBE) or "stream" (XREADGROUP)`)
+	subscribeCmd.Flags().StringVar(&Group, "group", "", "Stream mode: consumer group of this relay (default hostname)")
+	subscribeCmd.Flags().StringVar(&Consumer, "consumer", "", "Stream mode: consumer name within the group (default hostname)")
+	subscribeCmd.Flags().Int("backups", 0, "Number of previous output files to keep as <output>.1, <output>.2, ...")
+	subscribeCmd.Flags().StringSlice("trusted-keys", nil, "PEM Ed25519 public keys of trusted publishers. Topologies that are not signed by one of these keys are refused")
+	subscribeCmd.Flags().Duration("max-signature-age", defaultMaxTopologyAge, "With --trusted-keys, topologies signed longer than this ago are refused as replayed")
+	subscribeCmd.Flags().String("status-addr", "", `Serve /health and /status on this address, eg. ":8090"`)
+	addTopologyFlags(subscribeCmd.Flags())
+	addHookFlags(subscribeCmd.Flags())
+	registerCommandFlags(subscribeCmd)
 }
 
 func isJSON(s string) bool {
@@ -61,44 +82,218 @@ func isJSON(s string) bool {
 
 }
 
-func subscribe(cmd *cobra.Command, args []string) {
-	if Password == "" {
-		Password = viper.GetString("password")
+// convertTopology renders a published topology in the requested format.
+// Published P2P topologies and legacy topologies requested in the legacy format are kept as is.
+func convertTopology(flags *flag.FlagSet, payload string) ([]byte, error) {
+	format, _ := flags.GetString("format")
+	var topology struct {
+		pkg.PullPayload
+		PublicRoots []pkg.PublicRootGroup `json:"publicRoots"`
 	}
-	// Create a new Redis Client
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     Addr,
-		Password: Password,
-		DB:       0,
-	})
-	err := redisClient.Ping(context.Background()).Err()
+	if err := json.Unmarshal([]byte(payload), &topology); err != nil {
+		return nil, err
+	}
+	if format == formatLegacy || topology.PublicRoots != nil {
+		return []byte(payload), nil
+	}
+	return renderTopology(flags, topology.PullPayload, nil)
+}
+
+// subscriber applies published topologies to the output file
+type subscriber struct {
+	flags    *flag.FlagSet
+	client   *redis.Client
+	hook     *pkg.Hook
+	verifier *sign.Verifier
+	backups  int
+	status   *pkg.SubscriberStatus
+	schedule *pkg.Schedule
+	// signedAt is the signing time of the last applied topology
+	signedAt time.Time
+}
+
+// applyTopology writes a published topology to the output file and triggers
+// the reload hook if the file changed. Invalid topologies are logged and rejected
+// with an error wrapping pkg.ErrTopologyRejected.
+func (s *subscriber) applyTopology(version string, payload string) error {
+	s.status.Received()
+	var signedAt time.Time
+	if s.verifier != nil {
+		envelope, keyID, err := s.verifier.Open(sign.TopicScope(Topic), []byte(payload))
+		if err != nil {
+			return s.reject(version, err)
+		}
+		if envelope.SignedAt.Before(s.signedAt) {
+			return s.reject(version, fmt.Errorf("signed at %v, before the applied topology", envelope.SignedAt))
+		}
+		log.Debugf("Topology signed by key %s at %v", keyID, envelope.SignedAt)
+		payload = string(envelope.Payload)
+		signedAt = envelope.SignedAt
+	} else if envelope, ok := sign.ParseEnvelope([]byte(payload)); ok {
+		payload = string(envelope.Payload)
+	}
+	if !isJSON(payload) {
+		return s.reject(version, fmt.Errorf("invalid json"))
+	}
+	out, err := convertTopology(s.flags, payload)
 	if err != nil {
-		time.Sleep(3 * time.Second)
-		err := redisClient.Ping(context.Background()).Err()
+		return s.reject(version, err)
+	}
+	if err := pkg.ValidateTopology(out); err != nil {
+		return s.reject(version, err)
+	}
+	defer s.status.Applied(version)
+	if !signedAt.IsZero() {
+		s.signedAt = signedAt
+	}
+	if !pkg.TopologyChanged(Output, out) {
+		log.Infof("Topology '%s' is unchanged", Output)
+		return nil
+	}
+	if err := pkg.WriteFileAtomic(Output, out, s.backups); err != nil {
+		return fmt.Errorf("cannot write topology: %v", err)
+	}
+	log.Infof("Topology '%s' updated", Output)
+	if err := s.hook.Trigger(); err != nil {
+		log.Errorf("Reload hook failed: %v", err)
+	}
+	return nil
+}
+
+func (s *subscriber) reject(version string, err error) error {
+	log.Errorf("Rejecting topology %s: %v", version, err)
+	s.status.Rejected(err)
+	return fmt.Errorf("%w: %v", pkg.ErrTopologyRejected, err)
+}
+
+// consumePubSub applies the topologies published on the topic until the connection fails
+func (s *subscriber) consumePubSub(ctx context.Context) error {
+	pubsub := s.client.Subscribe(ctx, Topic)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	s.connected()
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if err := s.applyTopology("", msg.Payload); err != nil && !errors.Is(err, pkg.ErrTopologyRejected) {
+			return err
 		}
 	}
-	ctx := context.Background()
-	topic := redisClient.Subscribe(ctx, Topic)
-	channel := topic.Channel()
-	for msg := range channel {
-		if !isJSON(msg.Payload) {
-			err := fmt.Errorf("invalid json")
-			panic(err)
+}
+
+// consumeStream applies the topologies of the stream until the connection fails
+func (s *subscriber) consumeStream(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	s.connected()
+	stream := pkg.NewTopologyStream(s.client, Topic)
+	return stream.Consume(ctx, Group, Consumer, func(msg *pkg.TopologyMessage) error {
+		log.Infof("Applying topology version %s", msg.Version)
+		return s.applyTopology(msg.Version, string(msg.Topology))
+	})
+}
+
+func (s *subscriber) connected() {
+	log.Infof("Subscribed to '%s' on %s", Topic, Addr)
+	s.status.Connected()
+	s.schedule.Reset()
+}
+
+// run consumes topologies until SIGINT or SIGTERM is received, reconnecting with
+// an exponential backoff whenever the connection to Redis fails
+func (s *subscriber) run(consume func(ctx context.Context) error) {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	for {
+		err := consume(ctx)
+		if ctx.Err() != nil {
+			return
 		}
-		tmp, err := ioutil.TempFile("", "tempfile")
-		if err != nil {
-			panic(err)
+		s.status.Disconnected(err)
+		delay := s.schedule.Next(err)
+		log.Errorf("Subscription to '%s' failed, reconnecting in %v: %v", Topic, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
-		if _, err := tmp.Write([]byte(msg.Payload)); err != nil {
-			panic(err)
+	}
+}
+
+func subscribe(cmd *cobra.Command, args []string) {
+	if err := loadFlags(cmd.Name(), cmd.Flags()); err != nil {
+		log.Errorf("Unable to load flags: %v", err)
+		os.Exit(1)
+	}
+	if Output == "" {
+		log.Errorf("An output file is required, set --output or P2P_SUBSCRIBE_OUTPUT")
+		os.Exit(1)
+	}
+	if Password == "" && viper.IsSet("password") {
+		log.Warnf("password: deprecated, use subscribe.password")
+		Password = viper.GetString("password")
+	}
+	hook, err := newHook(cmd.Flags())
+	if err != nil {
+		log.Errorf("Invalid reload hook: %v", err)
+		os.Exit(1)
+	}
+	s := &subscriber{
+		flags: cmd.Flags(),
+		client: redis.NewClient(&redis.Options{
+			Addr:     Addr,
+			Password: Password,
+			DB:       0,
+		}),
+		hook:   hook,
+		status: pkg.NewSubscriberStatus(Mode, Topic),
+		schedule: &pkg.Schedule{
+			Interval:   maxReconnectDelay,
+			Jitter:     defaultWatchJitter,
+			RetryDelay: minReconnectDelay,
+		},
+	}
+	defer s.client.Close()
+	s.backups, _ = cmd.Flags().GetInt("backups")
+	if trustedKeys, _ := cmd.Flags().GetStringSlice("trusted-keys"); len(trustedKeys) > 0 {
+		if s.verifier, err = sign.LoadVerifier(trustedKeys...); err != nil {
+			log.Errorf("Invalid trusted keys: %v", err)
+			os.Exit(1)
 		}
-		if err := tmp.Close(); err != nil {
-			panic(err)
+		s.verifier.MaxAge, _ = cmd.Flags().GetDuration("max-signature-age")
+	}
+
+	var consume func(ctx context.Context) error
+	switch Mode {
+	case publishModeStream:
+		hostname, _ := os.Hostname()
+		if Group == "" {
+			Group = hostname
 		}
-		if err := os.Rename(tmp.Name(), Output); err != nil {
-			panic(err)
+		if Consumer == "" {
+			Consumer = hostname
 		}
+		consume = s.consumeStream
+	case publishModePubSub:
+		consume = s.consumePubSub
+	default:
+		log.Errorf("Unknown mode '%s'", Mode)
+		os.Exit(1)
+	}
+
+	if statusAddr, _ := cmd.Flags().GetString("status-addr"); statusAddr != "" {
+		go func() {
+			log.Infof("Serving subscriber status on %s", statusAddr)
+			if err := http.ListenAndServe(statusAddr, s.status); err != nil {
+				log.Errorf("Status endpoint failed: %v", err)
+			}
+		}()
 	}
+	s.run(consume)
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/regel/cardano-p2p/pkg"
+	flag "github.com/spf13/pflag"
+)
+
+func addTopologyFlags(flags *flag.FlagSet) {
+	flags.String("format", formatLegacy, heredoc.Doc(`
+Topology file format: "legacy" ({"Producers":[...]}) or "p2p" (localRoots/publicRoots)`))
+	flags.Int64("use-ledger-after-slot", defaultUseLedgerAfterSlot, heredoc.Doc(`
+P2P format only: slot after which the node may use ledger peers, a negative value disables ledger peers`))
+	flags.String("bootstrap-peers", "", heredoc.Doc(`
+P2P format only: bootstrap peers (IP,port) used by Genesis nodes, eg: "backbone.cardano.iog.io,3001"`))
+}
+
+// renderTopology returns the indented topology file in the requested format.
+// Custom producers become local roots in the P2P format.
+func renderTopology(flags *flag.FlagSet, payload pkg.PullPayload, custom []pkg.Producer) ([]byte, error) {
+	format, _ := flags.GetString("format")
+	var out interface{}
+	switch format {
+	case formatLegacy:
+		payload.Producers = append(payload.Producers, custom...)
+		out = payload
+	case formatP2P:
+		useLedgerAfterSlot, _ := flags.GetInt64("use-ledger-after-slot")
+		bootstrapPeers, _ := flags.GetString("bootstrap-peers")
+		var bootstrap []pkg.Producer
+		if bootstrapPeers != "" {
+			bootstrap = decodeProducers(bootstrapPeers)
+		}
+		out = pkg.NewP2PTopology(payload.Producers, custom, bootstrap, useLedgerAfterSlot)
+	default:
+		return nil, fmt.Errorf("unknown topology format '%s'", format)
+	}
+	dst := &bytes.Buffer{}
+	data, _ := json.Marshal(out)
+	if err := json.Indent(dst, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
+}
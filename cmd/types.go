@@ -1,7 +1,14 @@
 package cmd
 
 const (
-	testnetMagic    = 1097911063
+	defaultNetwork  = "mainnet"
 	defaultNodePort = 6001
 	defaultEndpoint = "https://api.clio.one"
+
+	formatLegacy              = "legacy"
+	formatP2P                 = "p2p"
+	defaultUseLedgerAfterSlot = -1
+
+	publishModePubSub = "pubsub"
+	publishModeStream = "stream"
 )
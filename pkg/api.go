@@ -0,0 +1,236 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/pkg/client"
+	"github.com/regel/cardano-p2p/server"
+	"gopkg.in/validator.v1"
+)
+
+type PeersRequest struct {
+	Magic     uint64
+	Count     int     `validate:"min=1,max=1000"`
+	IpVersion int     `validate:"min=0,max=6"`
+	MinStake  float64 `validate:"min=0"`
+}
+
+type PeersPayload struct {
+	Date     string `json:"datetime"`
+	ClientIp string `json:"clientIp"`
+	Magic    uint64 `json:"magic"`
+	Tip      int64  `json:"tip"`
+	Peers    []Peer `json:"peers"`
+}
+
+type PoolsPayload struct {
+	CycleStartedAt time.Time     `json:"cycleStartedAt"`
+	CycleEndedAt   time.Time     `json:"cycleEndedAt"`
+	Pools          []*PoolReport `json:"pools"`
+}
+
+const maxTipReportLen = 1024
+
+type ErrorPayload struct {
+	Error string `json:"error"`
+}
+
+func getClientIp(r *http.Request) (string, error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	if forward := r.Header.Get("X-Forwarded-For"); forward != "" {
+		return forward, nil
+	}
+	return ip, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, ErrorPayload{Error: msg})
+}
+
+// parsePeersRequest reads the v2 peers query parameters:
+// magic, count, ipv, minStake (share of the live stake between 0 and 1)
+// and exclude, a comma separated list of pool ids
+func parsePeersRequest(config *server.ServerConfig, r *http.Request) (*PeersRequest, map[string]bool, error) {
+	query := r.URL.Query()
+	t := &PeersRequest{
+		Magic: config.NetworkMagic,
+		Count: config.MaxPeers,
+	}
+	var err error
+	if s := query.Get("magic"); s != "" {
+		if t.Magic, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid magic '%s'", s)
+		}
+	}
+	if s := query.Get("count"); s != "" {
+		if t.Count, err = strconv.Atoi(s); err != nil {
+			return nil, nil, fmt.Errorf("invalid count '%s'", s)
+		}
+	}
+	if s := query.Get("ipv"); s != "" {
+		if t.IpVersion, err = strconv.Atoi(s); err != nil {
+			return nil, nil, fmt.Errorf("invalid ipv '%s'", s)
+		}
+	}
+	if s := query.Get("minStake"); s != "" {
+		if t.MinStake, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid minStake '%s'", s)
+		}
+	}
+	if ok, errs := validator.Validate(t); !ok {
+		return nil, nil, fmt.Errorf("validation failed: %v", errs)
+	}
+	if t.IpVersion != 0 && t.IpVersion != 4 && t.IpVersion != 6 {
+		return nil, nil, fmt.Errorf("invalid ipv '%d'", t.IpVersion)
+	}
+	exclude := make(map[string]bool)
+	for _, s := range query["exclude"] {
+		for _, poolId := range strings.Split(s, ",") {
+			if poolId != "" {
+				exclude[poolId] = true
+			}
+		}
+	}
+	return t, exclude, nil
+}
+
+func writePeers(config *server.ServerConfig, w http.ResponseWriter, r *http.Request, networks Networks, gossip *Gossip) {
+	clientIp, err := getClientIp(r)
+	if err != nil {
+		log.Infof("userip: %q is not IP:port", r.RemoteAddr)
+		writeError(w, 400, "invalid client address")
+		return
+	}
+	network, err := networks.Route(r)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	t, exclude, err := parsePeersRequest(&network.Config.Server, r)
+	if err != nil {
+		log.Infof("bad peers request: %v", err)
+		writeError(w, 400, err.Error())
+		return
+	}
+	peers := network.Peers
+	list := peers.List(PeerFilter{
+		IpVersion:   t.IpVersion,
+		Count:       t.Count,
+		ExcludePool: exclude,
+		MinStake:    t.MinStake,
+		Healthy: func(addr string, port int) bool {
+			return gossip.Healthy(network.Magic(), addr, port)
+		},
+	})
+	peersReturned.WithLabelValues("v2").Observe(float64(len(list)))
+	writeJSON(w, 200, PeersPayload{
+		Date:     time.Now().UTC().Format(time.RFC3339),
+		ClientIp: clientIp,
+		Magic:    t.Magic,
+		Tip:      peers.Tip(),
+		Peers:    list,
+	})
+}
+
+// writeTipReport records the block height reported by the relay listening
+// on the client address and the reported port
+func writeTipReport(w http.ResponseWriter, r *http.Request, networks Networks) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// the tip is recorded for the connected address, X-Forwarded-For is not trusted
+	clientIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		writeError(w, 400, "invalid client address")
+		return
+	}
+	network, err := networks.Route(r)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	var report client.TipReport
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTipReportLen)).Decode(&report); err != nil {
+		writeError(w, 400, fmt.Sprintf("invalid tip report: %v", err))
+		return
+	}
+	if report.Port < 1 || report.Port > 65535 || report.BlockNo < 0 {
+		writeError(w, 400, "invalid port or blockNo")
+		return
+	}
+	network.Peers.ReportTip(clientIp, int(report.Port), report.BlockNo)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePools lists the pools seen during the last cycle,
+// optionally restricted to one verdict with the verdict query parameter
+func writePools(w http.ResponseWriter, r *http.Request, networks Networks) {
+	network, err := networks.Route(r)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	pools := network.Pools
+	startedAt, endedAt := pools.Cycle()
+	writeJSON(w, 200, PoolsPayload{
+		CycleStartedAt: startedAt,
+		CycleEndedAt:   endedAt,
+		Pools:          pools.List(Verdict(r.URL.Query().Get("verdict"))),
+	})
+}
+
+func writePool(w http.ResponseWriter, r *http.Request, networks Networks) {
+	network, err := networks.Route(r)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	pools := network.Pools
+	poolId := strings.TrimPrefix(r.URL.Path, "/api/v2/pools/")
+	if poolId == "" || strings.Contains(poolId, "/") {
+		writeError(w, 404, "not found")
+		return
+	}
+	report, ok := pools.Get(poolId)
+	if !ok {
+		writeError(w, 404, "pool not seen during the last cycle")
+		return
+	}
+	writeJSON(w, 200, report)
+}
+
+// writeSnapshot returns the Genesis peer snapshot of the last cycle.
+// The stakePercent query parameter overrides the configured cumulative stake cut.
+func writeSnapshot(w http.ResponseWriter, r *http.Request, networks Networks) {
+	network, err := networks.Route(r)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	stakePercent := network.Config.Server.SnapshotStakePercent
+	if s := r.URL.Query().Get("stakePercent"); s != "" {
+		if stakePercent, err = strconv.ParseFloat(s, 64); err != nil || stakePercent <= 0 || stakePercent > 100 {
+			writeError(w, 400, fmt.Sprintf("invalid stakePercent '%s'", s))
+			return
+		}
+	}
+	writeJSON(w, 200, network.Pools.Snapshot(stakePercent))
+}
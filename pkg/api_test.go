@@ -0,0 +1,274 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/regel/cardano-p2p/server"
+	"github.com/stretchr/testify/require"
+)
+
+func samplePeerSet() *PeerSet {
+	peers := NewPeerSet()
+	peers.Update(Peer{
+		PoolId:     "pool1a",
+		Ticker:     "AAA",
+		Stake:      0.01,
+		Addr:       "10.0.0.1",
+		Port:       3001,
+		Valency:    1,
+		Family:     FamilyIpv4,
+		IpVersions: []int{4},
+		LastProbe:  time.Now(),
+	})
+	peers.Update(Peer{
+		PoolId:     "pool1b",
+		Ticker:     "BBB",
+		Stake:      0.0001,
+		Addr:       "2001:db8::1",
+		Port:       3001,
+		Valency:    1,
+		Family:     FamilyIpv6,
+		IpVersions: []int{6},
+		LastProbe:  time.Now(),
+	})
+	peers.Update(Peer{
+		PoolId:     "pool1c",
+		Stake:      0.001,
+		Addr:       "10.0.0.3",
+		Port:       6000,
+		Valency:    1,
+		Family:     FamilyIpv4,
+		IpVersions: []int{4},
+		LastProbe:  time.Now(),
+	})
+	return peers
+}
+
+// sampleNetworks returns a single default network with the given state
+func sampleNetworks(peers *PeerSet, pools *PoolDirectory) Networks {
+	network := NewNetwork(server.DefaultConfig())
+	if peers != nil {
+		network.Peers = peers
+	}
+	if pools != nil {
+		network.Pools = pools
+	}
+	return Networks{network}
+}
+
+func getPeers(t *testing.T, peers *PeerSet, query string) (int, *PeersPayload) {
+	config := server.DefaultConfig()
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/peers?"+query, nil)
+	w := httptest.NewRecorder()
+	writePeers(&config.Server, w, req, sampleNetworks(peers, nil), nil)
+	if w.Code != 200 {
+		return w.Code, nil
+	}
+	var payload PeersPayload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payload))
+	return w.Code, &payload
+}
+
+func TestPeersFilters(t *testing.T) {
+	peers := samplePeerSet()
+
+	code, payload := getPeers(t, peers, "")
+	require.Equal(t, 200, code)
+	require.Len(t, payload.Peers, 3)
+
+	_, payload = getPeers(t, peers, "ipv=6")
+	require.Len(t, payload.Peers, 1)
+	require.Equal(t, "pool1b", payload.Peers[0].PoolId)
+
+	_, payload = getPeers(t, peers, "exclude=pool1a,pool1b")
+	require.Len(t, payload.Peers, 1)
+	require.Equal(t, "pool1c", payload.Peers[0].PoolId)
+
+	_, payload = getPeers(t, peers, "minStake=0.001")
+	require.Len(t, payload.Peers, 2)
+
+	_, payload = getPeers(t, peers, "count=1")
+	require.Len(t, payload.Peers, 1)
+}
+
+func TestPeersBadRequest(t *testing.T) {
+	peers := samplePeerSet()
+	for _, query := range []string{"count=0", "ipv=5", "minStake=abc", "magic=1"} {
+		code, _ := getPeers(t, peers, query)
+		require.Equal(t, 400, code, query)
+	}
+}
+
+func TestPeersTipLag(t *testing.T) {
+	peers := samplePeerSet()
+	peers.SetTip(1000)
+	peers.ReportTip("10.0.0.1", 3001, 990)
+
+	_, payload := getPeers(t, peers, "exclude=pool1b,pool1c")
+	require.Len(t, payload.Peers, 1)
+	require.NotNil(t, payload.Peers[0].TipLag)
+	require.EqualValues(t, 10, *payload.Peers[0].TipLag)
+	require.EqualValues(t, 1000, payload.Tip)
+
+	_, payload = getPeers(t, peers, "exclude=pool1a,pool1b")
+	require.Nil(t, payload.Peers[0].TipLag)
+}
+
+func TestPeerSetExpire(t *testing.T) {
+	peers := samplePeerSet()
+	peers.Expire(time.Now().Add(time.Second))
+	require.Equal(t, 0, peers.Len())
+}
+
+func samplePoolDirectory() *PoolDirectory {
+	pools := NewPoolDirectory()
+	pools.Replace([]*PoolReport{
+		newPoolReport("pool1a", &PoolParameters{Id: "pool1a", Ticker: "AAA"}, nil),
+		newPoolReport("pool1b", nil, &VetError{VerdictHashMismatch, fmt.Errorf("invalid hash")}),
+	}, 0, time.Now(), time.Now())
+	return pools
+}
+
+func TestPools(t *testing.T) {
+	pools := sampleNetworks(nil, samplePoolDirectory())
+
+	w := httptest.NewRecorder()
+	writePools(w, httptest.NewRequest(http.MethodGet, "/api/v2/pools", nil), pools)
+	require.Equal(t, 200, w.Code)
+	var payload PoolsPayload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payload))
+	require.Len(t, payload.Pools, 2)
+	require.Equal(t, "pool1a", payload.Pools[0].PoolId)
+
+	w = httptest.NewRecorder()
+	writePools(w, httptest.NewRequest(http.MethodGet, "/api/v2/pools?verdict=hash-mismatch", nil), pools)
+	payload = PoolsPayload{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payload))
+	require.Len(t, payload.Pools, 1)
+	require.Equal(t, VerdictHashMismatch, payload.Pools[0].Verdict)
+	require.Equal(t, "invalid hash", payload.Pools[0].Error)
+}
+
+func TestPool(t *testing.T) {
+	pools := sampleNetworks(nil, samplePoolDirectory())
+
+	w := httptest.NewRecorder()
+	writePool(w, httptest.NewRequest(http.MethodGet, "/api/v2/pools/pool1a", nil), pools)
+	require.Equal(t, 200, w.Code)
+	var report PoolReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, "AAA", report.Ticker)
+	require.Equal(t, VerdictVetted, report.Verdict)
+
+	w = httptest.NewRecorder()
+	writePool(w, httptest.NewRequest(http.MethodGet, "/api/v2/pools/pool1z", nil), pools)
+	require.Equal(t, 404, w.Code)
+}
+
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestPeersMetrics(t *testing.T) {
+	peers := samplePeerSet()
+	before := sampleCount(t, peersReturned.WithLabelValues("v2"))
+	getPeers(t, peers, "count=2")
+	require.Equal(t, before+1, sampleCount(t, peersReturned.WithLabelValues("v2")))
+}
+
+func TestNetworksRoute(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Networks = []server.NetworkConfig{
+		{Name: "mainnet", NetworkMagic: 764824073},
+		{Name: "preprod", NetworkMagic: 1, SnapshotStakePercent: 50},
+	}
+	networks := make(Networks, 0)
+	for _, c := range config.NetworkConfigs() {
+		networks = append(networks, NewNetwork(c))
+	}
+	networks[1].Peers = samplePeerSet()
+	require.Equal(t, 50.0, networks[1].Config.Server.SnapshotStakePercent)
+	require.Equal(t, config.Server.SnapshotStakePercent, networks[0].Config.Server.SnapshotStakePercent)
+
+	w := httptest.NewRecorder()
+	writePeers(&config.Server, w, httptest.NewRequest(http.MethodGet, "/api/v2/peers", nil), networks, nil)
+	var payload PeersPayload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payload))
+	require.Equal(t, uint64(764824073), payload.Magic)
+	require.Empty(t, payload.Peers)
+
+	w = httptest.NewRecorder()
+	writePeers(&config.Server, w, httptest.NewRequest(http.MethodGet, "/api/v2/peers?magic=1", nil), networks, nil)
+	payload = PeersPayload{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payload))
+	require.Equal(t, uint64(1), payload.Magic)
+	require.Len(t, payload.Peers, 3)
+
+	w = httptest.NewRecorder()
+	writePeers(&config.Server, w, httptest.NewRequest(http.MethodGet, "/api/v2/peers?magic=2", nil), networks, nil)
+	require.Equal(t, 400, w.Code)
+}
+
+func TestTipReport(t *testing.T) {
+	peers := samplePeerSet()
+	peers.SetTip(1000)
+	networks := sampleNetworks(peers, nil)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/tips", strings.NewReader(`{"port":3001,"blockNo":990}`))
+	req.RemoteAddr = "10.0.0.1:40000"
+	writeTipReport(w, req, networks)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	_, payload := getPeers(t, peers, "exclude=pool1b,pool1c")
+	require.EqualValues(t, 10, *payload.Peers[0].TipLag)
+
+	// a report moves neither the reference tip nor the tip of the forwarded address
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v2/tips", strings.NewReader(`{"port":3001,"blockNo":999999}`))
+	req.RemoteAddr = "10.0.0.9:40000"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	writeTipReport(w, req, networks)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.EqualValues(t, 1000, peers.Tip())
+	_, payload = getPeers(t, peers, "exclude=pool1b,pool1c")
+	require.EqualValues(t, 10, *payload.Peers[0].TipLag)
+
+	w = httptest.NewRecorder()
+	writeTipReport(w, httptest.NewRequest(http.MethodGet, "/api/v2/tips", nil), networks)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	writeTipReport(w, httptest.NewRequest(http.MethodPost, "/api/v2/tips", strings.NewReader(`{"port":0}`)), networks)
+	require.Equal(t, 400, w.Code)
+}
+
+func TestTipReportRequiresAuth(t *testing.T) {
+	config := server.DefaultConfig()
+	post := func(token string) int {
+		mux := newServeMux(&config.Server, sampleNetworks(nil, nil), nil)
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/tips", strings.NewReader(`{"port":3001,"blockNo":990}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusUnauthorized, post(""))
+
+	config.Server.TipToken = "secret"
+	require.Equal(t, http.StatusUnauthorized, post(""))
+	require.Equal(t, http.StatusUnauthorized, post("wrong"))
+	require.Equal(t, http.StatusNoContent, post("secret"))
+}
@@ -0,0 +1,293 @@
+// Package client is a client of the CLIO compatible topology API served
+// by api.clio.one and by the p2p command.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/sign"
+)
+
+const (
+	// DefaultEndpoint is the address of the CLIO topology API
+	DefaultEndpoint = "https://api.clio.one"
+
+	// VersionHeader is the header holding the version of the client
+	VersionHeader = "X-Cardano-P2P-Version"
+
+	fetchPath      = "/htopology/v1/fetch/"
+	pushPath       = "/htopology/v1/"
+	tipPath        = "/api/v2/tips"
+	maxResponseLen = 64 * 1024
+	maxRetryDelay  = 1 * time.Minute
+)
+
+// Version is sent in the User-Agent and version headers. It is set by the cardano-p2p CLI.
+var Version = "dev"
+
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	Timeout: 10 * time.Second,
+}
+
+// Client queries one endpoint of the topology API
+type Client struct {
+	endpoint   *url.URL
+	httpClient *http.Client
+	userAgent  string
+	retries    int
+	retryDelay time.Duration
+	verifier   *sign.Verifier
+	token      string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for requests, eg. to configure TLS or proxies
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithRetries retries failed requests up to retries times. The delay between
+// attempts starts at delay and doubles after each attempt, up to one minute.
+// Only network errors, server errors and temporary result codes are retried.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+// WithVerifier refuses responses that are not signed by one of the keys trusted by verifier
+func WithVerifier(verifier *sign.Verifier) Option {
+	return func(c *Client) {
+		c.verifier = verifier
+	}
+}
+
+// WithToken sends token as a bearer token, eg. to report tips to a p2p server
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client of the topology API at endpoint, eg. https://api.clio.one
+func New(endpoint string, options ...Option) (*Client, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %v", endpoint, err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint '%s': expecting an http(s) address", endpoint)
+	}
+	c := &Client{
+		endpoint:   base,
+		httpClient: defaultHTTPClient,
+		userAgent:  "cardano-p2p/" + Version,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+// Endpoint returns the address of the API
+func (c *Client) Endpoint() string {
+	return c.endpoint.String()
+}
+
+// FetchURL returns the address used to fetch up to max Cardano nodes
+func (c *Client) FetchURL(magic int64, max int64, ipVersion int64) string {
+	return c.url(fetchPath, url.Values{
+		"magic": []string{strconv.FormatInt(magic, 10)},
+		"max":   []string{strconv.FormatInt(max, 10)},
+		"ipv":   []string{strconv.FormatInt(ipVersion, 10)},
+	})
+}
+
+// PushURL returns the address used to push our ledger tip
+func (c *Client) PushURL(magic int64, port int64, blockNo int64) string {
+	return c.url(pushPath, url.Values{
+		"magic":   []string{strconv.FormatInt(magic, 10)},
+		"port":    []string{strconv.FormatInt(port, 10)},
+		"blockNo": []string{strconv.FormatInt(blockNo, 10)},
+	})
+}
+
+func (c *Client) url(path string, values url.Values) string {
+	relative := &url.URL{
+		Path:     path,
+		RawQuery: values.Encode(),
+	}
+	return c.endpoint.ResolveReference(relative).String()
+}
+
+// Fetch returns up to max active Cardano nodes of the given network and IP version
+func (c *Client) Fetch(ctx context.Context, magic int64, max int64, ipVersion int64) (*PullPayload, error) {
+	var payload PullPayload
+	if err := c.do(ctx, c.FetchURL(magic, max, ipVersion), &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// Push sends the blockNo of our node listening on port. The payload is also
+// returned along with a ResultError.
+func (c *Client) Push(ctx context.Context, magic int64, port int64, blockNo int64) (*PushPayload, error) {
+	var payload PushPayload
+	if err := c.do(ctx, c.PushURL(magic, port, blockNo), &payload); err != nil {
+		var resultErr *ResultError
+		if errors.As(err, &resultErr) {
+			return &payload, err
+		}
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// ReportTip sends the blockNo of our node listening on port to the v2 API of a p2p server,
+// which uses it to compute the tip lag of our relay
+func (c *Client) ReportTip(ctx context.Context, magic int64, port int64, blockNo int64) error {
+	body, _ := json.Marshal(TipReport{Port: port, BlockNo: blockNo})
+	u := c.url(tipPath, url.Values{"magic": {strconv.FormatInt(magic, 10)}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set(VersionHeader, Version)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseLen))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Endpoint: c.Endpoint(), StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+type resultPayload interface {
+	resultCode() (string, string)
+}
+
+func (c *Client) do(ctx context.Context, url string, payload resultPayload) error {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.get(ctx, url, payload)
+		if err == nil || attempt >= c.retries || !temporary(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// verify checks the signature of the response body to req, signed for the magic of req
+func (c *Client) verify(req *http.Request, resp *http.Response, body []byte) error {
+	signature := resp.Header.Get(sign.Header)
+	if signature == "" {
+		return sign.ErrUnsigned
+	}
+	signedAt, err := time.Parse(time.RFC3339Nano, resp.Header.Get(sign.SignedAtHeader))
+	if err != nil {
+		return sign.ErrBadSignature
+	}
+	_, err = c.verifier.Verify(sign.MagicScope(req.URL.Query().Get("magic")), signedAt, body, signature)
+	return err
+}
+
+// temporary returns true if the request that failed with err may succeed later.
+// Network errors such as refused connections are retried, cancellations are not.
+func temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var resultErr *ResultError
+	if errors.As(err, &resultErr) {
+		return resultErr.Temporary()
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (c *Client) get(ctx context.Context, url string, payload resultPayload) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set(VersionHeader, Version)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseLen))
+	if err != nil {
+		return fmt.Errorf("cannot read response of '%s': %v", c.Endpoint(), err)
+	}
+	if c.verifier != nil {
+		if err := c.verify(req, resp, buf); err != nil {
+			return fmt.Errorf("'%s': %w", c.Endpoint(), err)
+		}
+	}
+	// CLIO answers errors with a payload, sometimes along with an HTTP error status
+	if err := json.Unmarshal(buf, payload); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &StatusError{Endpoint: c.Endpoint(), StatusCode: resp.StatusCode}
+		}
+		return fmt.Errorf("invalid response of '%s': %v", c.Endpoint(), err)
+	}
+	if code, msg := payload.resultCode(); !strings.HasPrefix(code, "2") {
+		return &ResultError{Endpoint: c.Endpoint(), Code: code, Msg: msg}
+	}
+	return nil
+}
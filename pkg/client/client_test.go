@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/sign"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleFetchResponse = `{
+  "resultcode": "200",
+  "datetime": "2022-02-05 17:29:03",
+  "clientIp": "127.0.0.1",
+  "iptype": 4,
+  "msg": "",
+  "Producers": [{"addr": "23.94.134.119", "port": 5001, "valency": 1}]
+}`
+
+const sampleNotAllowedResponse = `{
+  "resultcode": "402",
+  "clientIp": "127.0.0.1",
+  "msg": "IP is not (yet) allowed to fetch this list"
+}`
+
+const sampleOutOfSyncResponse = `{
+  "resultcode": "503",
+  "clientIp": "127.0.0.1",
+  "msg": "blockNo 3195424 seems out of sync. please retry"
+}`
+
+const samplePushResponse = `{
+  "resultcode": "201",
+  "clientIp": "127.0.0.1",
+  "iptype": 4,
+  "msg": "nice to meet you"
+}`
+
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/htopology/v1/fetch/", r.URL.Path)
+		require.Equal(t, "764824073", r.URL.Query().Get("magic"))
+		require.Equal(t, "5", r.URL.Query().Get("max"))
+		require.Equal(t, "4", r.URL.Query().Get("ipv"))
+		require.Equal(t, "test-agent", r.Header.Get("User-Agent"))
+		require.Equal(t, Version, r.Header.Get(VersionHeader))
+		fmt.Fprint(w, sampleFetchResponse)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithUserAgent("test-agent"), WithHTTPClient(ts.Client()))
+	require.NoError(t, err)
+	payload, err := c.Fetch(context.Background(), 764824073, 5, 4)
+	require.NoError(t, err)
+	require.Equal(t, ResultCodeFetched, payload.ResultCode)
+	require.Equal(t, []Producer{{Addr: "23.94.134.119", Port: 5001, Valency: 1}}, payload.Producers)
+}
+
+func TestResultErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/htopology/v1/fetch/":
+			fmt.Fprint(w, sampleNotAllowedResponse)
+		case "/htopology/v1/":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, sampleOutOfSyncResponse)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL)
+	require.NoError(t, err)
+	payload, err := c.Fetch(context.Background(), 1, 5, 4)
+	require.Nil(t, payload)
+	require.True(t, IsResultCode(err, ResultCodeNotAllowed))
+	var resultErr *ResultError
+	require.True(t, errors.As(err, &resultErr))
+	require.False(t, resultErr.Temporary())
+	require.Contains(t, err.Error(), "not (yet) allowed")
+
+	push, err := c.Push(context.Background(), 1, 3001, 3195424)
+	require.True(t, IsResultCode(err, ResultCodeOutOfSync))
+	require.NotNil(t, push)
+	require.Equal(t, ResultCodeOutOfSync, push.ResultCode)
+}
+
+func TestStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL)
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusBadGateway, statusErr.StatusCode)
+	require.True(t, statusErr.Temporary())
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case 2:
+			fmt.Fprint(w, sampleOutOfSyncResponse)
+		default:
+			fmt.Fprint(w, samplePushResponse)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithRetries(2, time.Millisecond))
+	require.NoError(t, err)
+	payload, err := c.Push(context.Background(), 1, 3001, 10000)
+	require.NoError(t, err)
+	require.Equal(t, ResultCodeNew, payload.ResultCode)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// permanent errors are not retried
+	atomic.StoreInt32(&calls, 0)
+	notAllowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, sampleNotAllowedResponse)
+	}))
+	defer notAllowed.Close()
+	c, err = New(notAllowed.URL, WithRetries(2, time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetriesNetworkErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	var calls int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, samplePushResponse)
+	}))
+	defer ts.Close()
+	// the server comes up after the first attempt was refused
+	started := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		defer close(started)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		ts.Listener.Close()
+		ts.Listener = listener
+		ts.Start()
+	})
+	defer func() { <-started }()
+
+	c, err := New("http://"+addr, WithRetries(3, 200*time.Millisecond))
+	require.NoError(t, err)
+	payload, err := c.Push(context.Background(), 1, 3001, 10000)
+	require.NoError(t, err)
+	require.Equal(t, ResultCodeNew, payload.ResultCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// cancelled requests are not retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, temporary(fmt.Errorf("push: %w", ctx.Err())))
+}
+
+func TestNewInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "api.clio.one", "ftp://api.clio.one", "http://%zz"} {
+		_, err := New(endpoint)
+		require.Error(t, err, endpoint)
+	}
+	c, err := New("https://api.clio.one/base/")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.clio.one/htopology/v1/?blockNo=3&magic=1&port=3001", c.PushURL(1, 3001, 3))
+}
+
+func TestVerifier(t *testing.T) {
+	privPem, pubPem, err := sign.GenerateKey()
+	require.NoError(t, err)
+	priv, err := sign.ParsePrivateKey(privPem)
+	require.NoError(t, err)
+	pub, err := sign.ParsePublicKey(pubPem)
+	require.NoError(t, err)
+	signer := sign.NewSigner(priv)
+
+	signedAt := time.Now()
+	signed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := sign.MagicScope(r.URL.Query().Get("magic"))
+		w.Header().Set(sign.SignedAtHeader, signedAt.Format(time.RFC3339Nano))
+		w.Header().Set(sign.Header, signer.Sign(scope, signedAt, []byte(sampleFetchResponse)))
+		fmt.Fprint(w, sampleFetchResponse)
+	}))
+	defer signed.Close()
+	unsigned := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleFetchResponse)
+	}))
+	defer unsigned.Close()
+
+	c, err := New(signed.URL, WithVerifier(sign.NewVerifier(pub)))
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	require.NoError(t, err)
+
+	c, err = New(unsigned.URL, WithVerifier(sign.NewVerifier(pub)), WithRetries(2, time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	require.True(t, errors.Is(err, sign.ErrUnsigned))
+
+	c, err = New(signed.URL, WithVerifier(sign.NewVerifier()))
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	require.True(t, errors.Is(err, sign.ErrUnknownKey))
+
+	// a response replayed after the max age
+	verifier := sign.NewVerifier(pub)
+	verifier.MaxAge = time.Minute
+	signedAt = time.Now().Add(-time.Hour)
+	c, err = New(signed.URL, WithVerifier(verifier))
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), 1, 5, 4)
+	require.True(t, errors.Is(err, sign.ErrExpired))
+}
+
+func TestReportTip(t *testing.T) {
+	var report TipReport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/api/v2/tips", r.URL.Path)
+		require.Equal(t, "764824073", r.URL.Query().Get("magic"))
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, WithToken("secret"))
+	require.NoError(t, err)
+	require.NoError(t, c.ReportTip(context.Background(), 764824073, 3001, 1000))
+	require.Equal(t, TipReport{Port: 3001, BlockNo: 1000}, report)
+}
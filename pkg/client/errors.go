@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Result codes of the CLIO topology API
+const (
+	ResultCodeFetched     = "200"
+	ResultCodeNew         = "201"
+	ResultCodeWelcomeBack = "203"
+	ResultCodeBadRequest  = "400"
+	ResultCodeNotAllowed  = "402"
+	ResultCodeOutOfSync   = "503"
+)
+
+// ResultError is returned when the API answers with an error result code
+type ResultError struct {
+	Endpoint string
+	Code     string
+	Msg      string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("'%s' answered result code %s: %s", e.Endpoint, e.Code, e.Msg)
+}
+
+// Temporary returns true if the same request may succeed later,
+// eg. a push of a blockNo the server considers out of sync
+func (e *ResultError) Temporary() bool {
+	return strings.HasPrefix(e.Code, "5")
+}
+
+// StatusError is returned when the API answers with an HTTP error status and no payload
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("'%s' answered HTTP status %d %s", e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Temporary returns true for server errors and rate limiting
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsResultCode returns true if err is a ResultError with the given result code
+func IsResultCode(err error, code string) bool {
+	var resultErr *ResultError
+	return errors.As(err, &resultErr) && resultErr.Code == code
+}
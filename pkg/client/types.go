@@ -0,0 +1,41 @@
+package client
+
+// PushPayload is the response to a push of our ledger tip
+type PushPayload struct {
+	ResultCode string `json:"resultcode"`
+	Date       string `json:"datetime"`
+	ClientIp   string `json:"clientIp"`
+	IpType     int    `json:"iptype"`
+	Msg        string `json:"msg"`
+}
+
+// PullPayload is the response to a fetch of active Cardano nodes
+type PullPayload struct {
+	ResultCode string     `json:"resultcode"`
+	Date       string     `json:"datetime"`
+	ClientIp   string     `json:"clientIp"`
+	IpType     int        `json:"iptype"`
+	Msg        string     `json:"msg"`
+	Producers  []Producer `json:"Producers"`
+}
+
+// TipReport is the block height of a relay, reported to the v2 API of a p2p server
+type TipReport struct {
+	Port    int64 `json:"port"`
+	BlockNo int64 `json:"blockNo"`
+}
+
+// Producer is the address of a Cardano node in a legacy topology file
+type Producer struct {
+	Addr    string `json:"addr"`
+	Port    int    `json:"port"`
+	Valency int    `json:"valency"`
+}
+
+func (p *PushPayload) resultCode() (string, string) {
+	return p.ResultCode, p.Msg
+}
+
+func (p *PullPayload) resultCode() (string, string) {
+	return p.ResultCode, p.Msg
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Verdict is the outcome of vetting a pool
+type Verdict string
+
+const (
+	// VerdictQueryFailed means the pool parameters could not be read from the ledger
+	VerdictQueryFailed Verdict = "query-failed"
+	// VerdictNoRelays means the pool did not register any relay
+	VerdictNoRelays Verdict = "no-relays"
+	// VerdictMetadataFetchFailed means the pool metadata could not be downloaded
+	VerdictMetadataFetchFailed Verdict = "metadata-fetch-failed"
+	// VerdictHashMismatch means the pool metadata does not match the registered hash
+	VerdictHashMismatch Verdict = "hash-mismatch"
+	// VerdictVetted means the pool metadata was verified and relays are yet to be probed
+	VerdictVetted Verdict = "vetted"
+	// VerdictProbeFailed means none of the pool relays answered the probe
+	VerdictProbeFailed Verdict = "probe-failed"
+	// VerdictServed means at least one pool relay is served to peers
+	VerdictServed Verdict = "served"
+)
+
+// VetError is returned when a pool fails vetting
+type VetError struct {
+	Verdict Verdict
+	Err     error
+}
+
+func (e *VetError) Error() string {
+	return e.Err.Error()
+}
+
+// RelayReport is the result of probing one pool relay
+type RelayReport struct {
+	Addr           string    `json:"addr"`
+	Port           int       `json:"port"`
+	Family         string    `json:"family"`
+	Result         string    `json:"result"`
+	Error          string    `json:"error,omitempty"`
+	ProbeLatencyMs float64   `json:"probeLatencyMs,omitempty"`
+	ProbedAt       time.Time `json:"probedAt"`
+}
+
+// PoolReport is the vetting result of one pool during the last cycle
+type PoolReport struct {
+	PoolId     string          `json:"poolId"`
+	Ticker     string          `json:"ticker,omitempty"`
+	Stake      float64         `json:"stake"`
+	Verdict    Verdict         `json:"verdict"`
+	Error      string          `json:"error,omitempty"`
+	VettedAt   time.Time       `json:"vettedAt"`
+	Relays     []RelayReport   `json:"relays"`
+	Parameters *PoolParameters `json:"-"`
+}
+
+func newPoolReport(poolId string, parameters *PoolParameters, err error) *PoolReport {
+	report := &PoolReport{
+		PoolId:     poolId,
+		Verdict:    VerdictVetted,
+		VettedAt:   time.Now(),
+		Relays:     make([]RelayReport, 0),
+		Parameters: parameters,
+	}
+	if parameters != nil {
+		report.Ticker = parameters.Ticker
+	}
+	if err != nil {
+		report.Verdict = VerdictQueryFailed
+		if e, ok := err.(*VetError); ok {
+			report.Verdict = e.Verdict
+		}
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// PoolDirectory holds the pool reports of the last vetting cycle.
+// It is safe for concurrent use.
+type PoolDirectory struct {
+	mu        sync.RWMutex
+	pools     map[string]*PoolReport
+	slot      uint64
+	startedAt time.Time
+	endedAt   time.Time
+}
+
+// NewPoolDirectory returns an empty pool directory
+func NewPoolDirectory() *PoolDirectory {
+	return &PoolDirectory{
+		pools: make(map[string]*PoolReport),
+	}
+}
+
+// Replace stores the reports of a complete cycle started at the given ledger slot
+func (d *PoolDirectory) Replace(reports []*PoolReport, slot uint64, startedAt time.Time, endedAt time.Time) {
+	pools := make(map[string]*PoolReport, len(reports))
+	for _, report := range reports {
+		pools[report.PoolId] = report
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pools = pools
+	d.slot = slot
+	d.startedAt = startedAt
+	d.endedAt = endedAt
+}
+
+// Get returns the report of a pool
+func (d *PoolDirectory) Get(poolId string) (*PoolReport, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	report, ok := d.pools[poolId]
+	return report, ok
+}
+
+// List returns the reports sorted by pool id, optionally restricted to one verdict
+func (d *PoolDirectory) List(verdict Verdict) []*PoolReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make([]*PoolReport, 0, len(d.pools))
+	for _, report := range d.pools {
+		if verdict != "" && report.Verdict != verdict {
+			continue
+		}
+		out = append(out, report)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PoolId < out[j].PoolId })
+	return out
+}
+
+// Cycle returns the start and end time of the last cycle
+func (d *PoolDirectory) Cycle() (time.Time, time.Time) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.startedAt, d.endedAt
+}
+
+// Snapshot returns the big ledger pools of the last cycle
+func (d *PoolDirectory) Snapshot(stakePercent float64) *PeerSnapshot {
+	reports := d.List("")
+	d.mu.RLock()
+	slot := d.slot
+	d.mu.RUnlock()
+	return NewPeerSnapshot(reports, slot, stakePercent)
+}
@@ -7,62 +7,126 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
-	"strconv"
+	"sort"
+	"sync"
 	"time"
-)
 
-func buildUrl(endpoint string, magic int64, max int64, ipv int64) string {
-	base, err := url.Parse(endpoint)
-	if err != nil {
-		panic("Can't parse endpoint base url")
-	}
-	values := url.Values{
-		"magic": []string{strconv.FormatInt(magic, 10)},
-		"max":   []string{strconv.FormatInt(max, 10)},
-		"ipv":   []string{strconv.FormatInt(ipv, 10)},
-	}
-	relative := &url.URL{
-		Path:     "/htopology/v1/fetch/",
-		RawQuery: values.Encode(),
-	}
-
-	return base.ResolveReference(relative).String()
-}
+	"github.com/regel/cardano-p2p/pkg/client"
+)
 
-func Fetch(bg context.Context, clioEndpoint string, magic int64, fetchMax int64, ipVersion int64) ([]byte, error) {
-	var netTransport = &http.Transport{
-		Dial: (&net.Dialer{
+var rawHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
 			Timeout: 5 * time.Second,
-		}).Dial,
+		}).DialContext,
 		TLSHandshakeTimeout: 5 * time.Second,
-	}
-	var client = &http.Client{
-		Transport: netTransport,
-	}
+	},
+}
 
+// getRaw returns the response body of url, whatever its status
+func getRaw(bg context.Context, url string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(bg, requestMaxWaitTime)
 	defer cancel()
 
-	url := buildUrl(clioEndpoint, magic, fetchMax, ipVersion)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot create request: %v", err)
 	}
-	req.Close = true
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	req.Header.Set("User-Agent", "cardano-p2p/"+client.Version)
+	req.Header.Set(client.VersionHeader, client.Version)
+	resp, err := rawHTTPClient.Do(req)
 	if e, ok := err.(net.Error); ok && e.Timeout() {
 		return nil, fmt.Errorf("Get '%s' timeout", url)
 	} else if err != nil {
 		return nil, fmt.Errorf("Cannot do request: %v", err)
 	}
+	defer resp.Body.Close()
 	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseLen))
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get all response body at url '%s': %v", url, err)
 	}
 	return buf, nil
 }
+
+// Fetch returns the raw response of clioEndpoint to a fetch request.
+//
+// Deprecated: use client.Client.Fetch, which decodes the response and checks its result code.
+func Fetch(bg context.Context, clioEndpoint string, magic int64, fetchMax int64, ipVersion int64) ([]byte, error) {
+	c, err := client.New(clioEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return getRaw(bg, c.FetchURL(magic, fetchMax, ipVersion))
+}
+
+// FetchResult is the response of one endpoint queried by FetchAll
+type FetchResult struct {
+	Endpoint string
+	Payload  *PullPayload
+	Err      error
+}
+
+// FetchAll queries all endpoints in parallel and returns one result per endpoint, in order
+func FetchAll(ctx context.Context, endpoints []string, magic int64, fetchMax int64, ipVersion int64, options ...client.Option) []FetchResult {
+	var wg sync.WaitGroup
+	results := make([]FetchResult, len(endpoints))
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i].Endpoint = endpoint
+			c, err := client.New(endpoint, options...)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, requestMaxWaitTime)
+			defer cancel()
+			payload, err := c.Fetch(ctx, magic, fetchMax, ipVersion)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Payload = payload
+		}(i, endpoint)
+	}
+	wg.Wait()
+	return results
+}
+
+// MergeProducers deduplicates producers by address and port and keeps the ones returned
+// by at least quorum payloads. Producers returned by more payloads come first.
+func MergeProducers(payloads []*PullPayload, quorum int) []Producer {
+	type entry struct {
+		producer Producer
+		votes    int
+	}
+	entries := make([]*entry, 0)
+	seen := make(map[string]*entry)
+	for _, payload := range payloads {
+		voted := make(map[string]bool)
+		for _, p := range payload.Producers {
+			key := relayKey(p.Addr, p.Port)
+			if voted[key] {
+				continue
+			}
+			voted[key] = true
+			if e, ok := seen[key]; ok {
+				e.votes++
+				continue
+			}
+			e := &entry{producer: p, votes: 1}
+			seen[key] = e
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].votes > entries[j].votes })
+	out := make([]Producer, 0, len(entries))
+	for _, e := range entries {
+		if e.votes >= quorum {
+			out = append(out, e.producer)
+		}
+	}
+	return out
+}
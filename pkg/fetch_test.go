@@ -101,3 +101,71 @@ func TestFetchKo400Response(t *testing.T) {
 	require.NotNil(t, out)
 	require.EqualValues(t, sampleFetchKoResponse, strings.TrimSuffix(string(out), "\n"))
 }
+
+func TestFetchAll(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, sampleFetchOkResponse)
+	}))
+	defer ts.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "not json")
+	}))
+	defer bad.Close()
+
+	results := FetchAll(context.Background(), []string{ts.URL, bad.URL}, 1, 1, 4)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, ts.URL, results[0].Endpoint)
+	require.Len(t, results[0].Payload.Producers, 1)
+	require.Error(t, results[1].Err)
+	require.Nil(t, results[1].Payload)
+}
+
+func TestMergeProducers(t *testing.T) {
+	a := &PullPayload{Producers: []Producer{
+		{Addr: "10.0.0.1", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.2", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.2", Port: 3001, Valency: 1},
+	}}
+	b := &PullPayload{Producers: []Producer{
+		{Addr: "10.0.0.3", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.2", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.1", Port: 3002, Valency: 1},
+	}}
+	c := &PullPayload{Producers: []Producer{
+		{Addr: "10.0.0.2", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.3", Port: 3001, Valency: 1},
+	}}
+
+	merged := MergeProducers([]*PullPayload{a, b, c}, 1)
+	require.Len(t, merged, 4)
+	require.Equal(t, "10.0.0.2", merged[0].Addr)
+
+	merged = MergeProducers([]*PullPayload{a, b, c}, 2)
+	require.Equal(t, []Producer{
+		{Addr: "10.0.0.2", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.3", Port: 3001, Valency: 1},
+	}, merged)
+
+	merged = MergeProducers([]*PullPayload{a, b, c}, 3)
+	require.Len(t, merged, 1)
+
+	// spellings of the same IP address are the same relay
+	d := &PullPayload{Producers: []Producer{
+		{Addr: "::ffff:10.0.0.1", Port: 3001, Valency: 1},
+		{Addr: "2001:DB8::0:1", Port: 3001, Valency: 1},
+	}}
+	e := &PullPayload{Producers: []Producer{
+		{Addr: "10.0.0.1", Port: 3001, Valency: 1},
+		{Addr: "2001:db8::1", Port: 3001, Valency: 1},
+	}}
+	merged = MergeProducers([]*PullPayload{d, e}, 2)
+	require.Len(t, merged, 2)
+}
+
+func TestFetchInvalidEndpoint(t *testing.T) {
+	_, err := Fetch(context.Background(), "http://%zz", 1, 1, 4)
+	require.Error(t, err)
+	results := FetchAll(context.Background(), []string{"api.clio.one"}, 1, 1, 4)
+	require.Error(t, results[0].Err)
+}
@@ -0,0 +1,283 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/server"
+)
+
+const (
+	gossipPath         = "/api/v2/gossip/observations"
+	maxObservationsLen = 4 * 1024 * 1024
+)
+
+// Observation is the result of the last probe of a relay of a network by one server
+type Observation struct {
+	Magic      uint64    `json:"magic"`
+	Addr       string    `json:"addr"`
+	Port       int       `json:"port"`
+	Reachable  bool      `json:"reachable"`
+	LatencyMs  int64     `json:"latencyMs,omitempty"`
+	ObservedAt time.Time `json:"observedAt"`
+}
+
+// ObservationReport holds the observations shared by one server, for all its networks
+type ObservationReport struct {
+	ServerId     string        `json:"serverId"`
+	Observations []Observation `json:"observations"`
+}
+
+// Gossip combines the probe observations of this server with the ones of its peers.
+// A relay is healthy when enough independent servers recently reached it on the same network.
+// Remote observations are keyed by the configured peer they were pulled from, not by the
+// server id the peer claims. It is safe for concurrent use.
+type Gossip struct {
+	mu       sync.RWMutex
+	serverId string
+	quorum   int
+	maxAge   time.Duration
+	local    map[string]Observation
+	remote   map[string]map[string]Observation
+}
+
+// NewGossip returns the gossip state of the server serverId
+func NewGossip(serverId string, quorum int, maxAge time.Duration) *Gossip {
+	return &Gossip{
+		serverId: serverId,
+		quorum:   quorum,
+		maxAge:   maxAge,
+		local:    make(map[string]Observation),
+		remote:   make(map[string]map[string]Observation),
+	}
+}
+
+// observationKey identifies a relay of the network magic
+func observationKey(magic uint64, addr string, port int) string {
+	return strconv.FormatUint(magic, 10) + "/" + peerKey(addr, port)
+}
+
+// Observe records the result of a probe of this server
+func (g *Gossip) Observe(magic uint64, addr string, port int, reachable bool, latencyMs int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.local[observationKey(magic, addr, port)] = Observation{
+		Magic:      magic,
+		Addr:       addr,
+		Port:       port,
+		Reachable:  reachable,
+		LatencyMs:  latencyMs,
+		ObservedAt: time.Now().UTC(),
+	}
+}
+
+// Report returns the recent observations of this server
+func (g *Gossip) Report() ObservationReport {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	report := ObservationReport{
+		ServerId:     g.serverId,
+		Observations: make([]Observation, 0, len(g.local)),
+	}
+	for _, o := range g.local {
+		if time.Since(o.ObservedAt) <= g.maxAge {
+			report.Observations = append(report.Observations, o)
+		}
+	}
+	return report
+}
+
+// Merge replaces the observations of the configured peer that sent report.
+// Observations dated in the future are clamped to the time they were received,
+// and stale observations of this server and of the peer are dropped.
+func (g *Gossip) Merge(peer string, report ObservationReport) error {
+	if report.ServerId == "" {
+		return fmt.Errorf("missing server id")
+	}
+	if report.ServerId == g.serverId {
+		return fmt.Errorf("peer uses our server id '%s'", g.serverId)
+	}
+	receivedAt := time.Now().UTC()
+	observations := make(map[string]Observation, len(report.Observations))
+	for _, o := range report.Observations {
+		if o.ObservedAt.After(receivedAt) {
+			o.ObservedAt = receivedAt
+		}
+		if receivedAt.Sub(o.ObservedAt) > g.maxAge {
+			continue
+		}
+		observations[observationKey(o.Magic, o.Addr, o.Port)] = o
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.remote[peer] = observations
+	for key, o := range g.local {
+		if receivedAt.Sub(o.ObservedAt) > g.maxAge {
+			delete(g.local, key)
+		}
+	}
+	return nil
+}
+
+// Votes returns the number of servers that reached the relay of the network magic recently,
+// this server included
+func (g *Gossip) Votes(magic uint64, addr string, port int) int {
+	key := observationKey(magic, addr, port)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	votes := 0
+	if o, ok := g.local[key]; ok && o.Reachable && time.Since(o.ObservedAt) <= g.maxAge {
+		votes++
+	}
+	for _, observations := range g.remote {
+		if o, ok := observations[key]; ok && o.Reachable && time.Since(o.ObservedAt) <= g.maxAge {
+			votes++
+		}
+	}
+	return votes
+}
+
+// Healthy returns true if at least quorum servers reached the relay of the network magic recently.
+// All relays are healthy without gossip.
+func (g *Gossip) Healthy(magic uint64, addr string, port int) bool {
+	if g == nil {
+		return true
+	}
+	return g.Votes(magic, addr, port) >= g.quorum
+}
+
+// Pull fetches the observations of the peer server at endpoint and merges them
+// as the observations of endpoint
+func (g *Gossip) Pull(ctx context.Context, httpClient *http.Client, endpoint string, token string) error {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	u := base.ResolveReference(&url.URL{Path: gossipPath}).String()
+	ctx, cancel := context.WithTimeout(ctx, requestMaxWaitTime)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Get '%s': %s", u, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxObservationsLen))
+	if err != nil {
+		return err
+	}
+	var report ObservationReport
+	if err := json.Unmarshal(buf, &report); err != nil {
+		return fmt.Errorf("Unmarshal error: %v", err)
+	}
+	return g.Merge(endpoint, report)
+}
+
+// NewGossipClient returns the http client used to reach peer servers,
+// presenting a client certificate if configured
+func NewGossipClient(config *server.GossipConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in '%s'", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}, nil
+}
+
+// GossipServerId returns the configured server id, or the hostname
+func GossipServerId(config *server.GossipConfig) string {
+	if config.ServerId != "" {
+		return config.ServerId
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// RunGossip pulls the observations of the peer servers every interval
+func RunGossip(config *server.GossipConfig, g *Gossip) {
+	httpClient, err := NewGossipClient(config)
+	if err != nil {
+		log.Errorf("Cannot create gossip client: %v", err)
+		return
+	}
+	for {
+		for _, peer := range config.Peers {
+			if err := g.Pull(context.Background(), httpClient, peer, config.Token); err != nil {
+				log.Errorf("Cannot pull observations from '%s': %v", peer, err)
+			}
+		}
+		<-time.After(config.Interval)
+	}
+}
+
+// requireGossipAuth lets through requests bearing the gossip token,
+// or a client certificate verified against the server client CA
+func requireGossipAuth(config *server.ServerConfig, next http.Handler) http.Handler {
+	return requireAuth(config, config.Gossip.Token, next)
+}
+
+// requireAuth requires the bearer token, when set, or a verified client certificate
+// when a client CA bundle is configured. Requests are refused without either.
+func requireAuth(config *server.ServerConfig, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if token != "" && strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if config.TLS.ClientCAFile != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// writeObservations sends the observations of this server to a peer
+func writeObservations(w http.ResponseWriter, r *http.Request, g *Gossip) {
+	writeJSON(w, http.StatusOK, g.Report())
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGossipHealthy(t *testing.T) {
+	var none *Gossip
+	require.True(t, none.Healthy(1, "10.0.0.1", 3001))
+
+	g := NewGossip("a", 2, time.Hour)
+	g.Observe(1, "10.0.0.1", 3001, true, 10)
+	g.Observe(1, "10.0.0.2", 3001, false, 0)
+	require.Equal(t, 1, g.Votes(1, "10.0.0.1", 3001))
+	require.False(t, g.Healthy(1, "10.0.0.1", 3001))
+
+	require.Error(t, g.Merge("https://b", ObservationReport{ServerId: "a"}))
+	require.Error(t, g.Merge("https://b", ObservationReport{}))
+	require.NoError(t, g.Merge("https://b", ObservationReport{
+		ServerId: "b",
+		Observations: []Observation{
+			{Magic: 1, Addr: "10.0.0.1", Port: 3001, Reachable: true, ObservedAt: time.Now()},
+			{Magic: 1, Addr: "10.0.0.2", Port: 3001, Reachable: true, ObservedAt: time.Now().Add(-2 * time.Hour)},
+		},
+	}))
+	require.True(t, g.Healthy(1, "10.0.0.1", 3001))
+	require.Equal(t, 0, g.Votes(1, "10.0.0.2", 3001))
+	require.Len(t, g.Report().Observations, 2)
+}
+
+func TestGossipMergeByPeer(t *testing.T) {
+	g := NewGossip("a", 3, time.Hour)
+	g.Observe(1, "10.0.0.1", 3001, true, 10)
+	// one peer posing as several servers counts once
+	for _, id := range []string{"b", "c"} {
+		require.NoError(t, g.Merge("https://b", ObservationReport{
+			ServerId: id,
+			Observations: []Observation{
+				{Magic: 1, Addr: "10.0.0.1", Port: 3001, Reachable: true, ObservedAt: time.Now()},
+			},
+		}))
+	}
+	require.Equal(t, 2, g.Votes(1, "10.0.0.1", 3001))
+
+	// an observation dated in the future expires as if received now
+	require.NoError(t, g.Merge("https://c", ObservationReport{
+		ServerId: "c",
+		Observations: []Observation{
+			{Magic: 1, Addr: "10.0.0.1", Port: 3001, Reachable: true, ObservedAt: time.Now().Add(24 * time.Hour)},
+		},
+	}))
+	require.True(t, g.Healthy(1, "10.0.0.1", 3001))
+	require.False(t, g.remote["https://c"][observationKey(1, "10.0.0.1", 3001)].ObservedAt.After(time.Now()))
+
+	// stale observations of this server are dropped
+	g.local[observationKey(1, "10.0.0.2", 3001)] = Observation{Magic: 1, Addr: "10.0.0.2", Port: 3001, ObservedAt: time.Now().Add(-2 * time.Hour)}
+	require.NoError(t, g.Merge("https://c", ObservationReport{ServerId: "c"}))
+	require.Len(t, g.local, 1)
+}
+
+func TestGossipNetworks(t *testing.T) {
+	g := NewGossip("a", 2, time.Hour)
+	g.Observe(1, "10.0.0.1", 3001, true, 10)
+	require.NoError(t, g.Merge("https://b", ObservationReport{
+		ServerId: "b",
+		Observations: []Observation{
+			{Magic: 2, Addr: "10.0.0.1", Port: 3001, Reachable: true, ObservedAt: time.Now()},
+		},
+	}))
+	// the same relay reached on another network does not count
+	require.Equal(t, 1, g.Votes(1, "10.0.0.1", 3001))
+	require.Equal(t, 1, g.Votes(2, "10.0.0.1", 3001))
+	require.False(t, g.Healthy(1, "10.0.0.1", 3001))
+	require.Equal(t, uint64(1), g.Report().Observations[0].Magic)
+}
+
+func TestGossipPull(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Server.Gossip.Token = "secret"
+	remote := NewGossip("b", 1, time.Hour)
+	remote.Observe(1, "10.0.0.1", 3001, true, 10)
+	ts := httptest.NewServer(requireGossipAuth(&config.Server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeObservations(w, r, remote)
+	})))
+	defer ts.Close()
+
+	g := NewGossip("a", 2, time.Hour)
+	require.Error(t, g.Pull(context.Background(), ts.Client(), ts.URL, "wrong"))
+	require.NoError(t, g.Pull(context.Background(), ts.Client(), ts.URL, "secret"))
+	require.Equal(t, 1, g.Votes(1, "10.0.0.1", 3001))
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/regel/cardano-p2p/log"
+)
+
+// Hook reloads cardano-node after a new topology has been written, either by sending
+// a signal to the process whose PID is in PidFile or by running Command.
+// Two runs are at least Debounce apart: a run triggered sooner is deferred,
+// and the triggers received meanwhile are merged into that single run.
+// Command is killed after Timeout, if set.
+type Hook struct {
+	PidFile  string
+	Signal   os.Signal
+	Command  string
+	Debounce time.Duration
+	Timeout  time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	pending *time.Timer
+}
+
+// NewHook returns a hook sending signal to the PID in pidFile and/or running command
+func NewHook(pidFile string, signal string, command string, debounce time.Duration, timeout time.Duration) (*Hook, error) {
+	h := &Hook{
+		PidFile:  pidFile,
+		Command:  command,
+		Debounce: debounce,
+		Timeout:  timeout,
+	}
+	if pidFile != "" {
+		sig, err := parseSignal(signal)
+		if err != nil {
+			return nil, err
+		}
+		h.Signal = sig
+	}
+	return h, nil
+}
+
+// Enabled returns true if the hook has something to run
+func (h *Hook) Enabled() bool {
+	return h != nil && (h.PidFile != "" || h.Command != "")
+}
+
+// Trigger runs the hook now, or schedules it at the end of the debounce period.
+// Errors of deferred runs are logged. The hook runs without holding the lock,
+// so that a slow command never blocks the callers of Trigger.
+func (h *Hook) Trigger() error {
+	if !h.Enabled() {
+		return nil
+	}
+	h.mu.Lock()
+	if h.pending != nil {
+		h.mu.Unlock()
+		return nil
+	}
+	wait := h.Debounce - time.Since(h.lastRun)
+	if h.lastRun.IsZero() || wait <= 0 {
+		h.lastRun = time.Now()
+		h.mu.Unlock()
+		return h.run()
+	}
+	log.Infof("Deferring reload hook by %v", wait)
+	h.pending = time.AfterFunc(wait, func() {
+		h.mu.Lock()
+		h.pending = nil
+		h.lastRun = time.Now()
+		h.mu.Unlock()
+		if err := h.run(); err != nil {
+			log.Errorf("Reload hook failed: %v", err)
+		}
+	})
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Hook) run() error {
+	if h.PidFile != "" {
+		if err := h.signal(); err != nil {
+			return err
+		}
+	}
+	if h.Command != "" {
+		log.Infof("Running '%s'", h.Command)
+		ctx := context.Background()
+		if h.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+		}
+		out, err := runCommand(ctx, h.Command)
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("command '%s' timed out after %v", h.Command, h.Timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("command '%s' failed: %v: %s", h.Command, err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// runCommand runs command with the shell and returns its combined output.
+// The command and the processes it started are killed when ctx is done.
+func runCommand(ctx context.Context, command string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// children of the shell would keep the output open
+			killProcessGroup(cmd.Process)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	return out.Bytes(), err
+}
+
+func (h *Hook) signal() error {
+	data, err := ioutil.ReadFile(h.PidFile)
+	if err != nil {
+		return fmt.Errorf("cannot read pid file: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file '%s': %v", h.PidFile, err)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	log.Infof("Sending %v to process %d", h.Signal, pid)
+	return process.Signal(h.Signal)
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func hookRuns(t *testing.T, filename string) int {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(string(data), "run")
+}
+
+func TestHookDebounce(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "runs")
+	hook, err := NewHook("", "", "echo run >> "+filename, 200*time.Millisecond, 0)
+	require.NoError(t, err)
+	require.True(t, hook.Enabled())
+
+	require.NoError(t, hook.Trigger())
+	require.Equal(t, 1, hookRuns(t, filename))
+
+	require.NoError(t, hook.Trigger())
+	require.NoError(t, hook.Trigger())
+	require.Equal(t, 1, hookRuns(t, filename))
+	require.Eventually(t, func() bool { return hookRuns(t, filename) == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, 2, hookRuns(t, filename))
+}
+
+func TestHookErrors(t *testing.T) {
+	var hook *Hook
+	require.False(t, hook.Enabled())
+	require.NoError(t, hook.Trigger())
+
+	_, err := NewHook("cardano-node.pid", "NOPE", "", 0, 0)
+	require.Error(t, err)
+
+	hook, err = NewHook(filepath.Join(t.TempDir(), "missing.pid"), "HUP", "", 0, 0)
+	require.NoError(t, err)
+	require.Error(t, hook.Trigger())
+
+	hook, err = NewHook("", "", "exit 3", 0, 0)
+	require.NoError(t, err)
+	require.Error(t, hook.Trigger())
+}
+
+func TestHookTimeout(t *testing.T) {
+	hook, err := NewHook("", "", "sleep 5; sleep 5", 0, 100*time.Millisecond)
+	require.NoError(t, err)
+	start := time.Now()
+	err = hook.Trigger()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+}
+
+func TestHookDeferredRunDoesNotBlock(t *testing.T) {
+	hook, err := NewHook("", "", "sleep 1", 300*time.Millisecond, 0)
+	require.NoError(t, err)
+	hook.lastRun = time.Now()
+	require.NoError(t, hook.Trigger())
+	// the deferred run sleeps while the next trigger is received
+	time.Sleep(400 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, hook.Trigger())
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookSignal(t *testing.T) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	defer signal.Stop(ch)
+
+	pidFile := filepath.Join(t.TempDir(), "cardano-node.pid")
+	require.NoError(t, ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644))
+	hook, err := NewHook(pidFile, "SIGUSR1", "", 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, hook.Trigger())
+
+	select {
+	case sig := <-ch:
+		require.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(time.Second):
+		t.Fatal("signal not received")
+	}
+}
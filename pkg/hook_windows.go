@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var shell = []string{"cmd", "/C"}
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills process, the processes it started are left running
+func killProcessGroup(process *os.Process) {
+	_ = process.Kill()
+}
+
+// parseSignal returns the signal named "KILL", the only signal that can be sent on Windows
+func parseSignal(name string) (os.Signal, error) {
+	if strings.TrimPrefix(strings.ToUpper(name), "SIG") != "KILL" {
+		return nil, fmt.Errorf("unsupported signal '%s'", name)
+	}
+	return os.Kill, nil
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "cardano_p2p"
+
+var (
+	poolsVetted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pools_vetted_total",
+		Help:      "Number of pools vetted, by network magic and verdict.",
+	}, []string{"network", "verdict"})
+
+	metadataFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "metadata_fetch_duration_seconds",
+		Help:      "Latency of pool metadata http requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+	}, []string{"result"})
+
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "probe_duration_seconds",
+		Help:      "Latency of relay probes, by address family and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 10),
+	}, []string{"family", "result"})
+
+	peerSetSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "peers",
+		Help:      "Number of relays that passed the last probe, by network magic.",
+	}, []string{"network"})
+
+	fetchRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_requests_total",
+		Help:      "Number of peer list requests, by api version and result code.",
+	}, []string{"api", "code"})
+
+	peersReturned = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "peers_returned",
+		Help:      "Number of peers returned per request.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+	}, []string{"api"})
+
+	cycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of a complete vetting and probing cycle, by network magic.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"network"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		poolsVetted,
+		metadataFetchDuration,
+		probeDuration,
+		peerSetSize,
+		fetchRequests,
+		peersReturned,
+		cycleDuration,
+	)
+}
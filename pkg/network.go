@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/regel/cardano-p2p/server"
+)
+
+// Network holds the state of one network served by the p2p process
+type Network struct {
+	Config    *server.Config
+	Producers chan Producer
+	Peers     *PeerSet
+	Pools     *PoolDirectory
+}
+
+// NewNetwork returns an empty network for config
+func NewNetwork(config *server.Config) *Network {
+	return &Network{
+		Config:    config,
+		Producers: make(chan Producer, config.Client.FetchMaximum),
+		Peers:     NewPeerSet(),
+		Pools:     NewPoolDirectory(),
+	}
+}
+
+// Magic returns the network magic
+func (n *Network) Magic() uint64 {
+	return n.Config.Server.NetworkMagic
+}
+
+// Networks are the networks served by the p2p process. The first one
+// answers requests without a magic query parameter.
+type Networks []*Network
+
+// Get returns the network with the given magic, or nil
+func (s Networks) Get(magic uint64) *Network {
+	for _, n := range s {
+		if n.Magic() == magic {
+			return n
+		}
+	}
+	return nil
+}
+
+// Route returns the network selected by the magic query parameter of r,
+// or the first network if the parameter is not set
+func (s Networks) Route(r *http.Request) (*Network, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("no network served")
+	}
+	str := r.URL.Query().Get("magic")
+	if str == "" {
+		return s[0], nil
+	}
+	magic, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magic '%s'", str)
+	}
+	n := s.Get(magic)
+	if n == nil {
+		return nil, fmt.Errorf("unknown network magic")
+	}
+	return n, nil
+}
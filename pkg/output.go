@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func backupName(filename string, i int) string {
+	return fmt.Sprintf("%s.%d", filename, i)
+}
+
+// rotateBackups shifts the numbered backups of filename and copies
+// its current content to filename.1, dropping the oldest backup
+func rotateBackups(filename string, backups int) error {
+	current, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i := backups - 1; i >= 1; i-- {
+		err := os.Rename(backupName(filename, i), backupName(filename, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFile(backupName(filename, 1), current)
+}
+
+// writeFile writes data to a temporary file in the directory of filename
+// and renames it, so that readers never see a partially written file
+func writeFile(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// WriteFileAtomic replaces filename with data using a temporary file and a rename.
+// Up to backups previous versions are kept as filename.1, filename.2, ...
+func WriteFileAtomic(filename string, data []byte, backups int) error {
+	if backups > 0 {
+		if err := rotateBackups(filename, backups); err != nil {
+			return fmt.Errorf("failed to rotate backups: %v", err)
+		}
+	}
+	return writeFile(filename, data)
+}
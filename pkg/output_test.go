@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "topology.json")
+
+	require.NoError(t, WriteFileAtomic(filename, []byte("first version"), 2))
+	require.NoError(t, WriteFileAtomic(filename, []byte("second"), 2))
+	require.NoError(t, WriteFileAtomic(filename, []byte("third"), 2))
+	require.NoError(t, WriteFileAtomic(filename, []byte("4th"), 2))
+
+	for name, content := range map[string]string{
+		"topology.json":   "4th",
+		"topology.json.1": "third",
+		"topology.json.2": "second",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	}
+	_, err := os.Stat(filepath.Join(dir, "topology.json.3"))
+	require.True(t, os.IsNotExist(err))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+}
+
+func TestWriteFileAtomicWithoutBackups(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "topology.json")
+
+	require.NoError(t, WriteFileAtomic(filename, []byte("a longer first version"), 0))
+	require.NoError(t, WriteFileAtomic(filename, []byte("short"), 0))
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "short", string(data))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
@@ -17,7 +17,16 @@ package pkg
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/regel/cardano-p2p/pkg/client"
 	"github.com/regel/cardano-p2p/pkg/probe"
+	"github.com/regel/cardano-p2p/pkg/sign"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/validator.v1"
 	"math/rand"
 	"net"
@@ -33,28 +42,11 @@ import (
 	"github.com/regel/cardano-p2p/server"
 )
 
-type PushPayload struct {
-	ResultCode string `json:"resultcode"`
-	Date       string `json:"datetime"`
-	ClientIp   string `json:"clientIp"`
-	IpType     int    `json:"iptype"`
-	Msg        string `json:"msg"`
-}
+type PushPayload = client.PushPayload
 
-type PullPayload struct {
-	ResultCode string     `json:"resultcode"`
-	Date       string     `json:"datetime"`
-	ClientIp   string     `json:"clientIp"`
-	IpType     int        `json:"iptype"`
-	Msg        string     `json:"msg"`
-	Producers  []Producer `json:"Producers"`
-}
+type PullPayload = client.PullPayload
 
-type Producer struct {
-	Addr    string `json:"addr"`
-	Port    int    `json:"port"`
-	Valency int    `json:"valency"`
-}
+type Producer = client.Producer
 
 type FetchRequest struct {
 	Magic     uint64 `validate:"min=0"`
@@ -62,73 +54,192 @@ type FetchRequest struct {
 	IpVersion int    `validate:"min=4"`
 }
 
-func Push(config *server.ClientConfig, ch chan<- Producer) {
+func Push(config *server.Config, ch chan<- Producer, peers *PeerSet, pools *PoolDirectory, gossip *Gossip) {
 	rand.Seed(time.Now().UnixNano())
-	push(config, ch)
+	push(config, ch, peers, pools, gossip)
 	for {
-		<-time.After(config.PeriodSeconds)
+		<-time.After(config.Client.PeriodSeconds)
 		rand.Seed(time.Now().UnixNano())
-		push(config, ch)
+		push(config, ch, peers, pools, gossip)
+	}
+}
+
+// relayAddr returns the address and address family of a pool relay
+func relayAddr(relay PoolRelay) (string, string) {
+	if relay.Ip4 != nil {
+		return *relay.Ip4, FamilyIpv4
+	} else if relay.Ip6 != nil {
+		return *relay.Ip6, FamilyIpv6
+	} else if relay.HostName != nil {
+		return *relay.HostName, FamilyHostname
+	}
+	return "", ""
+}
+
+// probeRelay probes a pool relay and returns the peer if the relay is reachable
+func probeRelay(ctx context.Context, config *server.Config, pool *PoolParameters, relay PoolRelay) (p *Peer, err error) {
+	_, span := tracer().Start(ctx, "probe", trace.WithAttributes(
+		attribute.String("pool.id", pool.Id),
+		attribute.String("probe.mode", config.Client.ProbeMode),
+	))
+	defer func() { endSpan(span, err) }()
+	peer := &Peer{
+		PoolId:  pool.Id,
+		Ticker:  pool.Ticker,
+		Stake:   pool.Stake,
+		Port:    relay.Port,
+		Valency: 1,
+	}
+	peer.Addr, peer.Family = relayAddr(relay)
+	switch peer.Family {
+	case FamilyIpv4:
+		peer.IpVersions = []int{4}
+	case FamilyIpv6:
+		peer.IpVersions = []int{6}
+	case FamilyHostname:
+	default:
+		return nil, fmt.Errorf("relay has no address")
+	}
+
+	addr := net.JoinHostPort(peer.Addr, strconv.Itoa(peer.Port))
+	span.SetAttributes(
+		attribute.String("relay.addr", addr),
+		attribute.String("relay.family", peer.Family),
+	)
+	var result probe.Result
+	start := time.Now()
+	if config.Client.ProbeMode == server.ProbeModeHandshake {
+		result, peer.HandshakeVersion, err = probe.DoHandshakeProbe(addr, config.Server.NetworkMagic, config.Client.ProbeTimeout)
+	} else {
+		result, err = probe.DoTCPProbe(addr, config.Client.ProbeTimeout)
+	}
+	elapsed := time.Since(start)
+	probeDuration.WithLabelValues(peer.Family, string(result)).Observe(elapsed.Seconds())
+	span.SetAttributes(attribute.String("probe.result", string(result)))
+	if result != probe.Success {
+		return nil, fmt.Errorf("%s probe to '%s' failed: %v", config.Client.ProbeMode, addr, err)
+	}
+	peer.LastProbe = time.Now()
+	peer.ProbeLatencyMs = float64(elapsed.Microseconds()) / 1000
+
+	if peer.Family == FamilyHostname {
+		ips, err := net.LookupIP(peer.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("dns lookup to '%s' failed: %v", addr, err)
+		}
+		peer.ips = ips
+		peer.Valency = len(ips)
+		for _, ip := range ips {
+			ipv := 6
+			if ip.To4() != nil {
+				ipv = 4
+			}
+			if !peer.hasIpVersion(ipv) {
+				peer.IpVersions = append(peer.IpVersions, ipv)
+			}
+		}
 	}
+	return peer, nil
 }
 
-func push(config *server.ClientConfig, ch chan<- Producer) {
-	pools, err := VetPools(config.Endpoint)
+func push(config *server.Config, ch chan<- Producer, peers *PeerSet, pools *PoolDirectory, gossip *Gossip) {
+	start := time.Now()
+	ctx, span := tracer().Start(context.Background(), "cycle")
+	defer span.End()
+	_, querySpan := tracer().Start(ctx, "ogmios.blockHeight")
+	blockNo, err := GetBlockHeight(config.Client.Endpoint)
+	endSpan(querySpan, err)
+	if err != nil {
+		log.Errorf("Could not get block height: %v", err)
+	} else if blockNo != nil {
+		peers.SetTip(*blockNo)
+	}
+	var slot uint64
+	_, querySpan = tracer().Start(ctx, "ogmios.chainTip")
+	chainTip, err := GetChainTip(config.Client.Endpoint)
+	endSpan(querySpan, err)
+	if err != nil {
+		log.Errorf("Could not get chain tip: %v", err)
+	} else {
+		slot = chainTip.Slot
+	}
+	reports, err := VetPools(ctx, config.Client.Endpoint)
 	if err != nil {
 		log.Errorf("Could not get pool data: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return
 	}
-	rand.Shuffle(len(pools), func(i, j int) { pools[i], pools[j] = pools[j], pools[i] })
-	for _, pool := range pools {
-		for _, relay := range pool.Relays {
-			if relay.Ip4 != nil || relay.Ip6 != nil {
-				if relay.Ip4 != nil {
-					addr := net.JoinHostPort(*relay.Ip4, strconv.Itoa(relay.Port))
-					tcpProbe, err := probe.DoTCPProbe(addr, config.ProbeTimeout)
-					if tcpProbe != probe.Success {
-						log.Errorf("tcp probe to '%s' failed: %v", addr, err)
-						continue
-					}
-					log.Infof("tcp probe to '%s' success", addr)
-					ch <- Producer{
-						Addr:    *relay.Ip4,
-						Port:    relay.Port,
-						Valency: 1,
-					}
-				} else if relay.Ip6 != nil {
-					addr := net.JoinHostPort(*relay.Ip6, strconv.Itoa(relay.Port))
-					tcpProbe, err := probe.DoTCPProbe(addr, config.ProbeTimeout)
-					if tcpProbe != probe.Success {
-						log.Errorf("tcp probe to '%s' failed: %v", addr, err)
-						continue
-					}
-					log.Infof("tcp probe to '%s' success", addr)
-					ch <- Producer{
-						Addr:    *relay.Ip6,
-						Port:    relay.Port,
-						Valency: 1,
-					}
-				}
-			} else if relay.HostName != nil {
-				addr := net.JoinHostPort(*relay.HostName, strconv.Itoa(relay.Port))
-				tcpProbe, err := probe.DoTCPProbe(addr, config.ProbeTimeout)
-				if tcpProbe != probe.Success {
-					log.Errorf("tcp probe to '%s' failed: %v", addr, err)
-					continue
-				}
-				ips, err := net.LookupIP(*relay.HostName)
-				val := len(ips)
-				if err != nil {
-					log.Errorf("dns lookup to '%s' failed: %v", addr, err)
-					continue
-				}
-				log.Infof("tcp probe to '%s' success", addr)
-				ch <- Producer{
-					Addr:    *relay.HostName,
-					Port:    relay.Port,
-					Valency: val,
+	rand.Shuffle(len(reports), func(i, j int) { reports[i], reports[j] = reports[j], reports[i] })
+	producers := make([]Producer, 0)
+	for _, report := range reports {
+		if report.Verdict != VerdictVetted {
+			continue
+		}
+		report.Verdict = VerdictProbeFailed
+		for _, relay := range report.Parameters.Relays {
+			addr, family := relayAddr(relay)
+			relayReport := RelayReport{
+				Addr:     addr,
+				Port:     relay.Port,
+				Family:   family,
+				Result:   string(probe.Success),
+				ProbedAt: time.Now(),
+			}
+			peer, err := probeRelay(ctx, config, report.Parameters, relay)
+			if err != nil {
+				log.Errorf("%v", err)
+				if gossip != nil && addr != "" {
+					gossip.Observe(config.Server.NetworkMagic, addr, relay.Port, false, 0)
 				}
+				relayReport.Result = string(probe.Failure)
+				relayReport.Error = err.Error()
+				report.Relays = append(report.Relays, relayReport)
+				continue
+			}
+			log.Infof("%s probe to '%s' success", config.Client.ProbeMode, peerKey(peer.Addr, peer.Port))
+			relayReport.ProbeLatencyMs = peer.ProbeLatencyMs
+			report.Relays = append(report.Relays, relayReport)
+			report.Verdict = VerdictServed
+			peers.Update(*peer)
+			if gossip != nil {
+				gossip.Observe(config.Server.NetworkMagic, peer.Addr, peer.Port, true, int64(peer.ProbeLatencyMs))
+			}
+			if !gossip.Healthy(config.Server.NetworkMagic, peer.Addr, peer.Port) {
+				log.Infof("'%s' lacks the gossip quorum", peerKey(peer.Addr, peer.Port))
+				continue
 			}
+			producers = append(producers, Producer{
+				Addr:    peer.Addr,
+				Port:    peer.Port,
+				Valency: peer.Valency,
+			})
+		}
+		if report.Verdict == VerdictProbeFailed {
+			report.Error = "no relay answered the probe"
+		}
+	}
+	peers.Expire(start)
+	pools.Replace(reports, slot, start, time.Now())
+	network := strconv.FormatUint(config.Server.NetworkMagic, 10)
+	for _, report := range reports {
+		poolsVetted.WithLabelValues(network, string(report.Verdict)).Inc()
+	}
+	peerSetSize.WithLabelValues(network).Set(float64(peers.Len()))
+	span.SetAttributes(attribute.Int("peers", peers.Len()))
+	cycleDuration.WithLabelValues(network).Observe(time.Since(start).Seconds())
+	queueProducers(ch, producers)
+}
+
+// queueProducers queues producers for the v1 fetch requests. Producers that
+// do not fit in the queue are dropped, so that a cycle never waits for fetches.
+func queueProducers(ch chan<- Producer, producers []Producer) {
+	for i, p := range producers {
+		select {
+		case ch <- p:
+		default:
+			log.Debugf("v1 queue is full, dropping %d producers", len(producers)-i)
+			return
 		}
 	}
 }
@@ -168,6 +279,7 @@ func writeFetch(config *server.ServerConfig, w http.ResponseWriter, t *FetchRequ
 		}
 	}
 
+	peersReturned.WithLabelValues("v1").Observe(float64(len(p)))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	pull := PullPayload{
@@ -181,7 +293,9 @@ func writeFetch(config *server.ServerConfig, w http.ResponseWriter, t *FetchRequ
 	_ = json.NewEncoder(w).Encode(pull)
 }
 
-func Serve(config *server.ServerConfig, ch chan Producer) {
+// newServeMux returns the routes of the p2p service. /health, /metrics and the v1 API
+// are public. With a client CA bundle, private routes require a verified client certificate.
+func newServeMux(config *server.ServerConfig, networks Networks, gossip *Gossip) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
@@ -210,7 +324,8 @@ func Serve(config *server.ServerConfig, ch chan Producer) {
 		w.WriteHeader(203)
 		_ = json.NewEncoder(w).Encode(p)
 	})
-	mux.HandleFunc("/htopology/v1/fetch/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/htopology/v1/fetch/", promhttp.InstrumentHandlerCounter(fetchRequests.MustCurryWith(prometheus.Labels{"api": "v1"}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var i int64
 		var err error
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -265,20 +380,65 @@ func Serve(config *server.ServerConfig, ch chan Producer) {
 			w.WriteHeader(400)
 			return
 		}
-		if t.Magic != config.NetworkMagic {
+		network := networks.Get(t.Magic)
+		if network == nil {
 			w.WriteHeader(400)
 			return
 		}
-		writeFetch(config, w, t, clientIp, ch, config.DefaultPeer)
-	})
+		writeFetch(config, w, t, clientIp, network.Producers, network.Config.Server.DefaultPeer)
+	})))
+	mux.Handle("/api/v2/peers", requireClientCert(config, config.TLS.RequireClientCert, promhttp.InstrumentHandlerCounter(fetchRequests.MustCurryWith(prometheus.Labels{"api": "v2"}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePeers(config, w, r, networks, gossip)
+	}))))
+	mux.Handle("/api/v2/pools", requireClientCert(config, config.TLS.RequireClientCert, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePools(w, r, networks)
+	})))
+	mux.Handle("/api/v2/pools/", requireClientCert(config, config.TLS.RequireClientCert, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePool(w, r, networks)
+	})))
+	mux.Handle("/api/v2/tips", requireAuth(config, config.TipToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTipReport(w, r, networks)
+	})))
+	mux.Handle("/api/v2/snapshot", requireClientCert(config, true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSnapshot(w, r, networks)
+	})))
+	if gossip != nil {
+		mux.Handle(gossipPath, requireGossipAuth(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeObservations(w, r, gossip)
+		})))
+	}
+	return mux
+}
+
+// Serve serves the networks over http. Requests are routed to a network
+// by their magic query parameter. shutdownTracing flushes the pending spans
+// when a termination signal is received.
+func Serve(config *server.ServerConfig, networks Networks, gossip *Gossip, shutdownTracing func(context.Context) error) {
+	mux := newServeMux(config, networks, gossip)
 
 	httpListener, err := net.Listen("tcp", config.ListenAddress)
 	if err != nil {
 		panic(err)
 	}
+	if config.TLS.Enabled() {
+		tlsConfig, err := NewTLSConfig(&config.TLS)
+		if err != nil {
+			panic(err)
+		}
+		httpListener = tls.NewListener(httpListener, tlsConfig)
+	}
+	handler := http.Handler(mux)
+	if config.SigningKey != "" {
+		signer, err := sign.LoadSigner(config.SigningKey)
+		if err != nil {
+			panic(err)
+		}
+		log.Infof("signing responses with key %s", signer.KeyID())
+		handler = signHandler(signer, mux)
+	}
 	httpServer := &http.Server{
 		Addr:    config.ListenAddress,
-		Handler: mux,
+		Handler: traceHandler(mux, handler),
 	}
 
 	log.Infof("listening: %s", config.ListenAddress)
@@ -303,6 +463,12 @@ func Serve(config *server.ServerConfig, ch chan Producer) {
 		}
 		cancel()
 
+		timeout, cancel = context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		if err := shutdownTracing(timeout); err != nil {
+			log.Errorf("tracing shutdown: %v", err)
+		}
+		cancel()
+
 		log.Infof("shutdown complete")
 		os.Exit(0)
 	}()
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dchest/blake2b"
+	"github.com/regel/cardano-p2p/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushWithoutFetches(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleMetadata)
+	}))
+	defer metadata.Close()
+	hash := fmt.Sprintf("%x", blake2b.Sum256([]byte(sampleMetadata)))
+	pools := make(map[string]PoolParameters)
+	for _, id := range []string{"pool1a", "pool1b"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		addr := "127.0.0.1"
+		relays := []PoolRelay{{Port: listener.Addr().(*net.TCPAddr).Port, Ip4: &addr}}
+		pools[id] = PoolParameters{Id: id, Relays: relays, Metadata: PoolMetadata{Url: metadata.URL + "/ok.json", Hash: hash}}
+	}
+	ogmios := newOgmiosServer(t, pools)
+	defer ogmios.Close()
+
+	config := server.DefaultConfig()
+	config.Client.Endpoint = "ws" + strings.TrimPrefix(ogmios.URL, "http")
+	config.Client.ProbeMode = server.ProbeModeTCP
+	// nobody reads the v1 queue
+	ch := make(chan Producer, 1)
+	peers := NewPeerSet()
+	directory := NewPoolDirectory()
+	done := make(chan struct{})
+	go func() {
+		push(config, ch, peers, directory, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push is blocked by the v1 queue")
+	}
+	require.Equal(t, 2, peers.Len())
+	require.Len(t, directory.List(VerdictServed), 2)
+	require.Len(t, ch, 1)
+}
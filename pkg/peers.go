@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	FamilyIpv4     = "ipv4"
+	FamilyIpv6     = "ipv6"
+	FamilyHostname = "hostname"
+)
+
+// Peer is a pool relay that passed the last probe
+type Peer struct {
+	PoolId           string    `json:"poolId"`
+	Ticker           string    `json:"ticker,omitempty"`
+	Stake            float64   `json:"stake"`
+	Addr             string    `json:"addr"`
+	Port             int       `json:"port"`
+	Valency          int       `json:"valency"`
+	Family           string    `json:"family"`
+	IpVersions       []int     `json:"ipVersions"`
+	LastProbe        time.Time `json:"lastProbe"`
+	ProbeLatencyMs   float64   `json:"probeLatencyMs"`
+	HandshakeVersion uint64    `json:"handshakeVersion,omitempty"`
+	TipLag           *int64    `json:"tipLag,omitempty"`
+	ips              []net.IP
+}
+
+// PeerFilter selects peers returned by PeerSet.List
+type PeerFilter struct {
+	IpVersion   int
+	Count       int
+	ExcludePool map[string]bool
+	MinStake    float64
+	// Healthy, if set, drops the peers it returns false for
+	Healthy func(addr string, port int) bool
+}
+
+// PeerSet holds the relays that passed the last probes along with their metadata.
+// It is safe for concurrent use.
+type PeerSet struct {
+	mu    sync.RWMutex
+	peers map[string]*Peer
+	tips  map[string]int64
+	tip   int64
+}
+
+// NewPeerSet returns an empty peer set
+func NewPeerSet() *PeerSet {
+	return &PeerSet{
+		peers: make(map[string]*Peer),
+		tips:  make(map[string]int64),
+	}
+}
+
+func peerKey(addr string, port int) string {
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+// Update adds or replaces a peer
+func (s *PeerSet) Update(peer Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[peerKey(peer.Addr, peer.Port)] = &peer
+}
+
+// Remove deletes a peer
+func (s *PeerSet) Remove(addr string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, peerKey(addr, port))
+}
+
+// Expire removes peers that were not probed successfully since t
+func (s *PeerSet) Expire(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, peer := range s.peers {
+		if peer.LastProbe.Before(t) {
+			delete(s.peers, key)
+		}
+	}
+}
+
+// Len returns the number of peers
+func (s *PeerSet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.peers)
+}
+
+// SetTip records the block height of our own node
+func (s *PeerSet) SetTip(blockNo int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if blockNo > s.tip {
+		s.tip = blockNo
+	}
+}
+
+// Tip returns the highest known block height
+func (s *PeerSet) Tip() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tip
+}
+
+// ReportTip records the block height pushed by a relay listening at ip:port.
+// Only SetTip moves the reference tip.
+func (s *PeerSet) ReportTip(ip string, port int, blockNo int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tips[peerKey(ip, port)] = blockNo
+}
+
+// tipLag returns how many blocks the peer is behind the highest known tip,
+// if the peer ever pushed its block height
+func (s *PeerSet) tipLag(peer *Peer) *int64 {
+	ips := peer.ips
+	if ip := net.ParseIP(peer.Addr); ip != nil {
+		ips = []net.IP{ip}
+	}
+	for _, ip := range ips {
+		if blockNo, ok := s.tips[peerKey(ip.String(), peer.Port)]; ok {
+			lag := s.tip - blockNo
+			return &lag
+		}
+	}
+	return nil
+}
+
+func (p *Peer) hasIpVersion(ipv int) bool {
+	for _, v := range p.IpVersions {
+		if v == ipv {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns a random selection of peers matching the filter
+func (s *PeerSet) List(filter PeerFilter) []Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		if filter.IpVersion != 0 && !peer.hasIpVersion(filter.IpVersion) {
+			continue
+		}
+		if filter.ExcludePool[peer.PoolId] {
+			continue
+		}
+		if peer.Stake < filter.MinStake {
+			continue
+		}
+		if filter.Healthy != nil && !filter.Healthy(peer.Addr, peer.Port) {
+			continue
+		}
+		p := *peer
+		p.TipLag = s.tipLag(peer)
+		out = append(out, p)
+	}
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	if filter.Count > 0 && len(out) > filter.Count {
+		out = out[:filter.Count]
+	}
+	return out
+}
@@ -0,0 +1,202 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Node-to-node handshake versions proposed by the handshake probe.
+const (
+	minLegacyVersion = 7
+	maxLegacyVersion = 10
+	minVersion       = 11
+	maxVersion       = 14
+)
+
+const (
+	muxHeaderLen        = 8
+	handshakeProtocolId = 0
+	maxHandshakeLen     = 5760
+
+	msgProposeVersions = 0
+	msgAcceptVersion   = 1
+	msgRefuse          = 2
+	msgQueryReply      = 3
+)
+
+// NewHandshake creates a Prober running the Ouroboros node-to-node handshake
+// for the given network magic.
+func NewHandshake(magic uint64) Prober {
+	return handshakeProber{magic: magic}
+}
+
+type handshakeProber struct {
+	magic uint64
+}
+
+// Probe returns Success if the remote node accepts one of the proposed versions.
+func (pr handshakeProber) Probe(host string, port int, timeout time.Duration) (Result, error) {
+	result, _, err := DoHandshakeProbe(net.JoinHostPort(host, strconv.Itoa(port)), pr.magic, timeout)
+	return result, err
+}
+
+// DoHandshakeProbe opens a TCP socket to the address and proposes node-to-node
+// protocol versions for the given network magic.
+// If the remote node accepts a version, it returns Success and the negotiated version.
+// If the socket fails to open or the versions are refused, it returns Failure.
+func DoHandshakeProbe(addr string, magic uint64, timeout time.Duration) (Result, uint64, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return Failure, 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return Failure, 0, err
+	}
+	if _, err := conn.Write(muxFrame(proposeVersions(magic))); err != nil {
+		return Failure, 0, err
+	}
+	payload, err := readMuxFrame(conn)
+	if err != nil {
+		return Failure, 0, err
+	}
+	version, err := acceptedVersion(payload)
+	if err != nil {
+		return Failure, 0, err
+	}
+	return Success, version, nil
+}
+
+func muxFrame(payload []byte) []byte {
+	frame := make([]byte, muxHeaderLen, muxHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(time.Now().UnixNano()/1000))
+	binary.BigEndian.PutUint16(frame[4:6], handshakeProtocolId)
+	binary.BigEndian.PutUint16(frame[6:8], uint16(len(payload)))
+	return append(frame, payload...)
+}
+
+func readMuxFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, muxHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("cannot read mux header: %v", err)
+	}
+	if id := binary.BigEndian.Uint16(header[4:6]) & 0x7fff; id != handshakeProtocolId {
+		return nil, fmt.Errorf("unexpected mini protocol %d", id)
+	}
+	length := binary.BigEndian.Uint16(header[6:8])
+	if length > maxHandshakeLen {
+		return nil, fmt.Errorf("handshake message too long: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("cannot read handshake message: %v", err)
+	}
+	return payload, nil
+}
+
+// proposeVersions encodes MsgProposeVersions with an initiator only,
+// peer sharing disabled version table.
+func proposeVersions(magic uint64) []byte {
+	var b bytes.Buffer
+	count := (maxLegacyVersion - minLegacyVersion + 1) + (maxVersion - minVersion + 1)
+	writeHead(&b, 4, 2)
+	writeHead(&b, 0, msgProposeVersions)
+	writeHead(&b, 5, uint64(count))
+	for v := uint64(minLegacyVersion); v <= maxLegacyVersion; v++ {
+		writeHead(&b, 0, v)
+		writeHead(&b, 4, 2)
+		writeHead(&b, 0, magic)
+		b.WriteByte(0xf5) // initiator only
+	}
+	for v := uint64(minVersion); v <= maxVersion; v++ {
+		writeHead(&b, 0, v)
+		writeHead(&b, 4, 4)
+		writeHead(&b, 0, magic)
+		b.WriteByte(0xf5) // initiator only
+		writeHead(&b, 0, 0)
+		b.WriteByte(0xf4) // no query
+	}
+	return b.Bytes()
+}
+
+func acceptedVersion(payload []byte) (uint64, error) {
+	r := bytes.NewReader(payload)
+	major, _, err := readHead(r)
+	if err != nil || major != 4 {
+		return 0, fmt.Errorf("malformed handshake message")
+	}
+	major, msg, err := readHead(r)
+	if err != nil || major != 0 {
+		return 0, fmt.Errorf("malformed handshake message")
+	}
+	switch msg {
+	case msgAcceptVersion:
+		major, version, err := readHead(r)
+		if err != nil || major != 0 {
+			return 0, fmt.Errorf("malformed accept version message")
+		}
+		return version, nil
+	case msgRefuse:
+		return 0, fmt.Errorf("handshake refused")
+	case msgQueryReply:
+		return 0, fmt.Errorf("unexpected query reply")
+	default:
+		return 0, fmt.Errorf("unexpected handshake message %d", msg)
+	}
+}
+
+// writeHead writes a CBOR data item head.
+func writeHead(b *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		b.WriteByte(m | byte(n))
+	case n <= 0xff:
+		b.WriteByte(m | 24)
+		b.WriteByte(byte(n))
+	case n <= 0xffff:
+		b.WriteByte(m | 25)
+		_ = binary.Write(b, binary.BigEndian, uint16(n))
+	case n <= 0xffffffff:
+		b.WriteByte(m | 26)
+		_ = binary.Write(b, binary.BigEndian, uint32(n))
+	default:
+		b.WriteByte(m | 27)
+		_ = binary.Write(b, binary.BigEndian, n)
+	}
+}
+
+// readHead reads a CBOR data item head and returns its major type and argument.
+func readHead(r *bytes.Reader) (byte, uint64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := c>>5, c&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n, err := r.ReadByte()
+		return major, uint64(n), err
+	case info == 25:
+		var n uint16
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, uint64(n), err
+	case info == 26:
+		var n uint32
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, uint64(n), err
+	case info == 27:
+		var n uint64
+		err := binary.Read(r, binary.BigEndian, &n)
+		return major, n, err
+	default:
+		return 0, 0, fmt.Errorf("unsupported cbor item 0x%x", c)
+	}
+}
@@ -0,0 +1,72 @@
+package probe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveHandshake answers the first handshake message with the given payload
+func serveHandshake(t *testing.T, reply []byte) (string, chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	received := make(chan []byte, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		payload, err := readMuxFrame(conn)
+		if err != nil {
+			return
+		}
+		received <- payload
+		_, _ = conn.Write(muxFrame(reply))
+	}()
+	return l.Addr().String(), received
+}
+
+func TestHandshakeAccepted(t *testing.T) {
+	var reply bytes.Buffer
+	writeHead(&reply, 4, 3)
+	writeHead(&reply, 0, msgAcceptVersion)
+	writeHead(&reply, 0, 13)
+	writeHead(&reply, 4, 4)
+	writeHead(&reply, 0, 764824073)
+	reply.Write([]byte{0xf5, 0x00, 0xf4})
+	addr, received := serveHandshake(t, reply.Bytes())
+
+	result, version, err := DoHandshakeProbe(addr, 764824073, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, Success, result)
+	require.EqualValues(t, 13, version)
+	require.Equal(t, proposeVersions(764824073), <-received)
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	var reply bytes.Buffer
+	writeHead(&reply, 4, 2)
+	writeHead(&reply, 0, msgRefuse)
+	writeHead(&reply, 4, 0)
+	addr, _ := serveHandshake(t, reply.Bytes())
+
+	result, _, err := DoHandshakeProbe(addr, 1, time.Second)
+	require.Error(t, err)
+	require.Equal(t, Failure, result)
+}
+
+func TestCborHead(t *testing.T) {
+	for _, n := range []uint64{0, 23, 24, 255, 256, 65535, 65536, 764824073, 1 << 40} {
+		var b bytes.Buffer
+		writeHead(&b, 0, n)
+		major, v, err := readHead(bytes.NewReader(b.Bytes()))
+		require.NoError(t, err)
+		require.EqualValues(t, 0, major)
+		require.Equal(t, n, v)
+	}
+}
@@ -2,67 +2,98 @@ package pkg
 
 import (
 	"context"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
-	"net/http"
-	"net/url"
-	"strconv"
+	"sync"
 	"time"
+
+	"github.com/regel/cardano-p2p/pkg/client"
 )
 
-func buildPushUrl(endpoint string, magic int64, port int64, blockNo int64) string {
-	base, err := url.Parse(endpoint)
+// PushBlockNo returns the raw response of clioEndpoint to a push of our ledger tip.
+//
+// Deprecated: use client.Client.Push, which decodes the response and checks its result code.
+func PushBlockNo(bg context.Context, clioEndpoint string, magic int64, port int64, blockNo int64) ([]byte, error) {
+	c, err := client.New(clioEndpoint)
 	if err != nil {
-		panic("Can't parse endpoint base url")
-	}
-	values := url.Values{
-		"magic":   []string{strconv.FormatInt(magic, 10)},
-		"port":    []string{strconv.FormatInt(port, 10)},
-		"blockNo": []string{strconv.FormatInt(blockNo, 10)},
-	}
-	relative := &url.URL{
-		Path:     "/htopology/v1/",
-		RawQuery: values.Encode(),
+		return nil, err
 	}
+	return getRaw(bg, c.PushURL(magic, port, blockNo))
+}
 
-	return base.ResolveReference(relative).String()
+// PushResult is the outcome of pushing our ledger tip to one endpoint
+type PushResult struct {
+	Endpoint string
+	Payload  *PushPayload
+	Attempts int
+	Err      error
 }
 
-func PushBlockNo(bg context.Context, clioEndpoint string, magic int64, port int64, blockNo int64) ([]byte, error) {
-	var netTransport = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout: 5 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 5 * time.Second,
-	}
-	var client = &http.Client{
-		Transport: netTransport,
+// PushAll pushes blockNo to all endpoints in parallel. Failed pushes, including
+// the ones answered with an error result code, are retried up to retries times.
+func PushAll(ctx context.Context, endpoints []string, magic int64, port int64, blockNo int64, retries int, retryDelay time.Duration) []PushResult {
+	var wg sync.WaitGroup
+	results := make([]PushResult, len(endpoints))
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			result := &results[i]
+			result.Endpoint = endpoint
+			c, err := client.New(endpoint)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			for {
+				result.Attempts++
+				pushCtx, cancel := context.WithTimeout(ctx, requestMaxWaitTime)
+				result.Payload, result.Err = c.Push(pushCtx, magic, port, blockNo)
+				cancel()
+				if result.Err == nil || result.Attempts > retries {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryDelay):
+				}
+			}
+		}(i, endpoint)
 	}
+	wg.Wait()
+	return results
+}
 
-	ctx, cancel := context.WithTimeout(bg, requestMaxWaitTime)
-	defer cancel()
+// PushStatus tracks the pushes to one endpoint across rounds
+type PushStatus struct {
+	Endpoint            string     `json:"endpoint"`
+	BlockNo             int64      `json:"blockNo"`
+	ResultCode          string     `json:"resultcode,omitempty"`
+	Msg                 string     `json:"msg,omitempty"`
+	Error               string     `json:"error,omitempty"`
+	Attempts            int        `json:"attempts"`
+	LastAttempt         time.Time  `json:"lastAttempt"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+}
 
-	url := buildPushUrl(clioEndpoint, magic, port, blockNo)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("Cannot create request: %v", err)
-	}
-	req.Close = true
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// Update records the result of the last push of blockNo
+func (s *PushStatus) Update(result PushResult, blockNo int64, at time.Time) {
+	s.Endpoint = result.Endpoint
+	s.BlockNo = blockNo
+	s.Attempts = result.Attempts
+	s.LastAttempt = at
+	s.ResultCode = ""
+	s.Msg = ""
+	s.Error = ""
+	if result.Payload != nil {
+		s.ResultCode = result.Payload.ResultCode
+		s.Msg = result.Payload.Msg
 	}
-	defer resp.Body.Close()
-	if e, ok := err.(net.Error); ok && e.Timeout() {
-		return nil, fmt.Errorf("Get '%s' timeout", url)
-	} else if err != nil {
-		return nil, fmt.Errorf("Cannot do request: %v", err)
-	}
-	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseLen))
-	if err != nil {
-		return nil, fmt.Errorf("Cannot get all response body at url '%s': %v", url, err)
+	if result.Err != nil {
+		s.Error = result.Err.Error()
+		s.ConsecutiveFailures++
+		return
 	}
-	return buf, nil
+	s.ConsecutiveFailures = 0
+	s.LastSuccess = &at
 }
@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -92,3 +94,43 @@ func TestPushKo400Response(t *testing.T) {
 	require.NotNil(t, out)
 	require.EqualValues(t, samplePushKoResponse, strings.TrimSuffix(string(out), "\n"))
 }
+
+func TestPushAll(t *testing.T) {
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			fmt.Fprintln(w, samplePushKoResponse)
+			return
+		}
+		fmt.Fprintln(w, samplePushOkResponse)
+	}))
+	defer flaky.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, samplePushKoResponse)
+	}))
+	defer failing.Close()
+
+	results := PushAll(context.Background(), []string{flaky.URL, failing.URL}, 1, 6001, 10000, 2, time.Millisecond)
+	require.Len(t, results, 2)
+	require.Equal(t, flaky.URL, results[0].Endpoint)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 2, results[0].Attempts)
+	require.Equal(t, "201", results[0].Payload.ResultCode)
+	require.Equal(t, failing.URL, results[1].Endpoint)
+	require.Error(t, results[1].Err)
+	require.Equal(t, 3, results[1].Attempts)
+	require.Equal(t, "503", results[1].Payload.ResultCode)
+
+	var status PushStatus
+	now := time.Now()
+	status.Update(results[1], 10000, now)
+	status.Update(results[1], 10001, now)
+	require.Equal(t, 2, status.ConsecutiveFailures)
+	require.Nil(t, status.LastSuccess)
+	require.Contains(t, status.Error, "out of sync")
+	status.Update(results[0], 10002, now)
+	require.Equal(t, 0, status.ConsecutiveFailures)
+	require.Equal(t, &now, status.LastSuccess)
+	require.Equal(t, int64(10002), status.BlockNo)
+	require.Empty(t, status.Error)
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Schedule computes the delay before the next run of a periodic task.
+// Runs are Interval apart, randomly shifted by up to Jitter times the delay so that
+// many instances do not hit the same endpoints at once. After a failure, the next run
+// is retried sooner, starting at RetryDelay and doubling after each consecutive
+// failure up to Interval.
+type Schedule struct {
+	Interval   time.Duration
+	Jitter     float64
+	RetryDelay time.Duration
+
+	failures int
+}
+
+// Next returns the delay before the next run, given the error of the last run
+func (s *Schedule) Next(err error) time.Duration {
+	delay := s.Interval
+	if err != nil {
+		s.failures++
+		delay = s.RetryDelay
+		for i := 1; i < s.failures && delay < s.Interval; i++ {
+			delay *= 2
+		}
+		if delay > s.Interval {
+			delay = s.Interval
+		}
+	} else {
+		s.failures = 0
+	}
+	if s.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * s.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Failures returns the number of consecutive failed runs
+func (s *Schedule) Failures() int {
+	return s.failures
+}
+
+// Reset clears the consecutive failures, eg. once a long running task recovered
+func (s *Schedule) Reset() {
+	s.failures = 0
+}
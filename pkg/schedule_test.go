@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule(t *testing.T) {
+	s := &Schedule{Interval: time.Hour, RetryDelay: 10 * time.Minute}
+	failure := errors.New("failure")
+
+	require.Equal(t, time.Hour, s.Next(nil))
+	require.Equal(t, 10*time.Minute, s.Next(failure))
+	require.Equal(t, 20*time.Minute, s.Next(failure))
+	require.Equal(t, 40*time.Minute, s.Next(failure))
+	require.Equal(t, time.Hour, s.Next(failure))
+	require.Equal(t, time.Hour, s.Next(failure))
+	require.Equal(t, 5, s.Failures())
+	require.Equal(t, time.Hour, s.Next(nil))
+	require.Equal(t, 0, s.Failures())
+	require.Equal(t, 10*time.Minute, s.Next(failure))
+}
+
+func TestScheduleJitter(t *testing.T) {
+	s := &Schedule{Interval: time.Hour, Jitter: 0.1, RetryDelay: time.Minute}
+	for i := 0; i < 100; i++ {
+		delay := s.Next(nil)
+		require.GreaterOrEqual(t, int64(delay), int64(54*time.Minute))
+		require.LessOrEqual(t, int64(delay), int64(66*time.Minute))
+	}
+}
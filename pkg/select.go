@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/probe"
+)
+
+// ProbedProducer is a producer along with the result of its probe
+type ProbedProducer struct {
+	Producer
+	Latency time.Duration
+	Err     error
+}
+
+// ProbeProducers probes all producers in parallel and measures their round-trip time
+func ProbeProducers(producers []Producer, prober probe.Prober, timeout time.Duration) []ProbedProducer {
+	var wg sync.WaitGroup
+	out := make([]ProbedProducer, len(producers))
+	ch := make(chan int)
+	wg.Add(MaxWorkers)
+	for i := 0; i < MaxWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				p := producers[i]
+				start := time.Now()
+				result, err := prober.Probe(p.Addr, p.Port, timeout)
+				out[i] = ProbedProducer{
+					Producer: p,
+					Latency:  time.Since(start),
+					Err:      err,
+				}
+				if result != probe.Success && err == nil {
+					out[i].Err = fmt.Errorf("%s probe failed", result)
+				}
+			}
+		}()
+	}
+	for i := range producers {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	return out
+}
+
+// SelectProducers keeps up to max reachable producers. The fastest producers are kept first,
+// and randomShare of the selection is picked at random among the remaining reachable producers
+// so that topologies do not collapse onto the closest region.
+func SelectProducers(probed []ProbedProducer, max int, randomShare float64) []Producer {
+	healthy := make([]ProbedProducer, 0, len(probed))
+	for _, p := range probed {
+		if p.Err == nil {
+			healthy = append(healthy, p)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].Latency < healthy[j].Latency })
+	if len(healthy) <= max {
+		out := make([]Producer, 0, len(healthy))
+		for _, p := range healthy {
+			out = append(out, p.Producer)
+		}
+		return out
+	}
+	random := int(math.Round(float64(max) * randomShare))
+	best := max - random
+	out := make([]Producer, 0, max)
+	for _, p := range healthy[:best] {
+		out = append(out, p.Producer)
+	}
+	rest := healthy[best:]
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	for _, p := range rest[:random] {
+		out = append(out, p.Producer)
+	}
+	return out
+}
+
+// ExcludeProducers returns the producers whose address and port are not in exclude
+func ExcludeProducers(producers []Producer, exclude []Producer) []Producer {
+	excluded := make(map[string]bool, len(exclude))
+	for _, p := range exclude {
+		excluded[relayKey(p.Addr, p.Port)] = true
+	}
+	out := make([]Producer, 0, len(producers))
+	for _, p := range producers {
+		if !excluded[relayKey(p.Addr, p.Port)] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// KeepHealthy returns up to max reachable producers, in their original order
+func KeepHealthy(probed []ProbedProducer, max int) []Producer {
+	out := make([]Producer, 0, max)
+	for _, p := range probed {
+		if len(out) >= max {
+			break
+		}
+		if p.Err == nil {
+			out = append(out, p.Producer)
+		}
+	}
+	return out
+}
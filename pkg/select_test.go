@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/probe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeProducers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	_, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, closedStr, _ := net.SplitHostPort(closed.Addr().String())
+	closedPort, _ := strconv.Atoi(closedStr)
+	closed.Close()
+
+	producers := []Producer{
+		{Addr: "127.0.0.1", Port: port, Valency: 1},
+		{Addr: "127.0.0.1", Port: closedPort, Valency: 1},
+	}
+	probed := ProbeProducers(producers, probe.New(), time.Second)
+	require.Len(t, probed, 2)
+	require.NoError(t, probed[0].Err)
+	require.Equal(t, producers[0], probed[0].Producer)
+	require.Error(t, probed[1].Err)
+}
+
+func TestSelectProducers(t *testing.T) {
+	probed := make([]ProbedProducer, 0)
+	for i := 0; i < 10; i++ {
+		probed = append(probed, ProbedProducer{
+			Producer: Producer{Addr: "10.0.0." + strconv.Itoa(i), Port: 3001, Valency: 1},
+			Latency:  time.Duration(10-i) * time.Millisecond,
+		})
+	}
+	probed[0].Err = errors.New("failure")
+
+	selected := SelectProducers(probed, 4, 0)
+	require.Equal(t, []string{"10.0.0.9", "10.0.0.8", "10.0.0.7", "10.0.0.6"}, addrs(selected))
+
+	selected = SelectProducers(probed, 4, 0.5)
+	require.Len(t, selected, 4)
+	require.Equal(t, []string{"10.0.0.9", "10.0.0.8"}, addrs(selected[:2]))
+	for _, p := range selected[2:] {
+		require.NotContains(t, []string{"10.0.0.0", "10.0.0.9", "10.0.0.8"}, p.Addr)
+	}
+
+	selected = SelectProducers(probed, 20, 0.5)
+	require.Len(t, selected, 9)
+}
+
+func addrs(producers []Producer) []string {
+	out := make([]string, 0, len(producers))
+	for _, p := range producers {
+		out = append(out, p.Addr)
+	}
+	return out
+}
+
+func TestKeepHealthy(t *testing.T) {
+	probed := []ProbedProducer{
+		{Producer: Producer{Addr: "10.0.0.1", Port: 3001}, Latency: 9 * time.Millisecond},
+		{Producer: Producer{Addr: "10.0.0.2", Port: 3001}, Err: errors.New("failure")},
+		{Producer: Producer{Addr: "10.0.0.3", Port: 3001}, Latency: time.Millisecond},
+		{Producer: Producer{Addr: "10.0.0.4", Port: 3001}},
+	}
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.3"}, addrs(KeepHealthy(probed, 2)))
+	require.Empty(t, KeepHealthy(probed, 0))
+}
+
+func TestExcludeProducers(t *testing.T) {
+	producers := []Producer{
+		{Addr: "10.0.0.1", Port: 3001},
+		{Addr: "10.0.0.1", Port: 3002},
+		{Addr: "Relay.Example.com", Port: 3001},
+	}
+	exclude := []Producer{
+		{Addr: "10.0.0.1", Port: 3001},
+		{Addr: "relay.example.com", Port: 3001},
+	}
+	require.Equal(t, []Producer{producers[1]}, ExcludeProducers(producers, exclude))
+}
@@ -0,0 +1,250 @@
+// Package sign signs topology payloads with Ed25519 keys and verifies them
+// against a set of trusted public keys.
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header holds the detached signature of an http response body, formatted as <key id>:<base64 signature>
+	Header = "X-Cardano-P2P-Signature"
+	// SignedAtHeader holds the signing time of an http response body, in RFC 3339 format
+	SignedAtHeader = "X-Cardano-P2P-Signed-At"
+)
+
+var (
+	// ErrUnsigned is returned when a payload has no signature
+	ErrUnsigned = errors.New("payload is not signed")
+	// ErrUnknownKey is returned when a payload is signed by a key that is not trusted
+	ErrUnknownKey = errors.New("payload is signed by an unknown key")
+	// ErrBadSignature is returned when a signature does not match the payload, its scope or signing time
+	ErrBadSignature = errors.New("invalid payload signature")
+	// ErrExpired is returned when a payload was signed longer than the max age of the verifier ago
+	ErrExpired = errors.New("payload signature is too old")
+)
+
+// MagicScope is the scope of the payloads served for a network magic
+func MagicScope(magic string) string {
+	return "magic=" + magic
+}
+
+// TopicScope is the scope of the payloads published on a Redis topic
+func TopicScope(topic string) string {
+	return "topic=" + topic
+}
+
+// signedData binds a payload to its scope and signing time, so that a signed payload
+// cannot be replayed for another network or topic
+func signedData(scope string, signedAt time.Time, payload []byte) []byte {
+	data := []byte(scope + "\n" + strconv.FormatInt(signedAt.UnixNano(), 10) + "\n")
+	return append(data, payload...)
+}
+
+// KeyID returns the identifier of a public key: the hex encoded first 8 bytes of its sha256 sum
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Signer signs payloads with a private key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner returns a signer using key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// KeyID returns the identifier of the public key matching the signing key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the detached signature of payload in scope at signedAt, formatted for Header
+func (s *Signer) Sign(scope string, signedAt time.Time, payload []byte) string {
+	return s.keyID + ":" + base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedData(scope, signedAt, payload)))
+}
+
+// Verifier verifies payloads against trusted public keys
+type Verifier struct {
+	// MaxAge refuses payloads signed longer than MaxAge ago. Zero accepts any age.
+	MaxAge time.Duration
+
+	keys map[string]ed25519.PublicKey
+}
+
+// NewVerifier returns a verifier trusting keys
+func NewVerifier(keys ...ed25519.PublicKey) *Verifier {
+	v := &Verifier{keys: make(map[string]ed25519.PublicKey, len(keys))}
+	for _, key := range keys {
+		v.keys[KeyID(key)] = key
+	}
+	return v
+}
+
+// Verify checks the detached signature of payload in scope at signedAt, formatted as returned
+// by Signer.Sign, and the age of the signature. It returns the id of the key that signed the payload.
+func (v *Verifier) Verify(scope string, signedAt time.Time, payload []byte, signature string) (string, error) {
+	if signature == "" {
+		return "", ErrUnsigned
+	}
+	parts := strings.SplitN(signature, ":", 2)
+	if len(parts) != 2 {
+		return "", ErrBadSignature
+	}
+	key, ok := v.keys[parts[0]]
+	if !ok {
+		return parts[0], ErrUnknownKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || !ed25519.Verify(key, signedData(scope, signedAt, payload), sig) {
+		return parts[0], ErrBadSignature
+	}
+	if v.MaxAge > 0 && time.Since(signedAt) > v.MaxAge {
+		return parts[0], ErrExpired
+	}
+	return parts[0], nil
+}
+
+// Envelope embeds a payload along with its scope, signing time and detached signature,
+// so that signed payloads can be published on Redis
+type Envelope struct {
+	Payload   []byte    `json:"payload"`
+	Scope     string    `json:"scope"`
+	SignedAt  time.Time `json:"signedAt"`
+	Signature string    `json:"signature"`
+}
+
+// Seal returns the envelope of payload in scope signed by s now, as JSON
+func (s *Signer) Seal(scope string, payload []byte) []byte {
+	signedAt := time.Now().UTC()
+	data, _ := json.Marshal(Envelope{
+		Payload:   payload,
+		Scope:     scope,
+		SignedAt:  signedAt,
+		Signature: s.Sign(scope, signedAt, payload),
+	})
+	return data
+}
+
+// Open returns an envelope of scope after verifying its signature.
+// Payloads that are not an envelope are refused as unsigned.
+func (v *Verifier) Open(scope string, data []byte) (*Envelope, string, error) {
+	envelope, ok := ParseEnvelope(data)
+	if !ok {
+		return nil, "", ErrUnsigned
+	}
+	keyID, err := v.Verify(scope, envelope.SignedAt, envelope.Payload, envelope.Signature)
+	if err != nil {
+		return nil, keyID, err
+	}
+	return envelope, keyID, nil
+}
+
+// ParseEnvelope returns the envelope found in data, and false if data is not an envelope
+func ParseEnvelope(data []byte) (*Envelope, bool) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Payload == nil || envelope.Signature == "" {
+		return nil, false
+	}
+	return &envelope, true
+}
+
+// GenerateKey returns a new key pair, PEM encoded
+func GenerateKey() ([]byte, []byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}),
+		nil
+}
+
+// ParsePrivateKey returns the PEM encoded PKCS #8 Ed25519 private key
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 private key")
+	}
+	return priv, nil
+}
+
+// ParsePublicKey returns the PEM encoded PKIX Ed25519 public key
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 public key")
+	}
+	return pub, nil
+}
+
+// LoadSigner returns a signer using the private key file
+func LoadSigner(filename string) (*Signer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key '%s': %v", filename, err)
+	}
+	return NewSigner(key), nil
+}
+
+// LoadVerifier returns a verifier trusting the public key files
+func LoadVerifier(filenames ...string) (*Verifier, error) {
+	keys := make([]ed25519.PublicKey, 0, len(filenames))
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key '%s': %v", filename, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewVerifier(keys...), nil
+}
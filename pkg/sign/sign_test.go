@@ -0,0 +1,108 @@
+package sign
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newKeys(t *testing.T) (*Signer, *Verifier, string) {
+	privPem, pubPem, err := GenerateKey()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	privFile := filepath.Join(dir, "signing.key")
+	pubFile := filepath.Join(dir, "signing.pub")
+	require.NoError(t, ioutil.WriteFile(privFile, privPem, 0600))
+	require.NoError(t, ioutil.WriteFile(pubFile, pubPem, 0644))
+	signer, err := LoadSigner(privFile)
+	require.NoError(t, err)
+	verifier, err := LoadVerifier(pubFile)
+	require.NoError(t, err)
+	return signer, verifier, pubFile
+}
+
+func TestSignVerify(t *testing.T) {
+	signer, verifier, _ := newKeys(t)
+	other, _, _ := newKeys(t)
+	payload := []byte(`{"Producers": []}`)
+	scope := MagicScope("764824073")
+	now := time.Now()
+
+	keyID, err := verifier.Verify(scope, now, payload, signer.Sign(scope, now, payload))
+	require.NoError(t, err)
+	require.Equal(t, signer.KeyID(), keyID)
+
+	_, err = verifier.Verify(scope, now, payload, "")
+	require.Equal(t, ErrUnsigned, err)
+	_, err = verifier.Verify(scope, now, payload, other.Sign(scope, now, payload))
+	require.Equal(t, ErrUnknownKey, err)
+	_, err = verifier.Verify(scope, now, []byte(`{"Producers": [1]}`), signer.Sign(scope, now, payload))
+	require.Equal(t, ErrBadSignature, err)
+	_, err = verifier.Verify(MagicScope("1"), now, payload, signer.Sign(scope, now, payload))
+	require.Equal(t, ErrBadSignature, err)
+	_, err = verifier.Verify(scope, now.Add(time.Second), payload, signer.Sign(scope, now, payload))
+	require.Equal(t, ErrBadSignature, err)
+	_, err = verifier.Verify(scope, now, payload, signer.KeyID()+":not base64")
+	require.Equal(t, ErrBadSignature, err)
+	_, err = verifier.Verify(scope, now, payload, "garbage")
+	require.Equal(t, ErrBadSignature, err)
+}
+
+func TestEnvelope(t *testing.T) {
+	signer, verifier, _ := newKeys(t)
+	payload := []byte(`{"Producers": []}`)
+	scope := TopicScope("p2p")
+
+	envelope, keyID, err := verifier.Open(scope, signer.Seal(scope, payload))
+	require.NoError(t, err)
+	require.Equal(t, payload, envelope.Payload)
+	require.WithinDuration(t, time.Now(), envelope.SignedAt, time.Minute)
+	require.Equal(t, signer.KeyID(), keyID)
+
+	_, _, err = verifier.Open(TopicScope("other"), signer.Seal(scope, payload))
+	require.Equal(t, ErrBadSignature, err)
+	_, _, err = verifier.Open(scope, payload)
+	require.Equal(t, ErrUnsigned, err)
+	_, ok := ParseEnvelope(payload)
+	require.False(t, ok)
+}
+
+func TestReplay(t *testing.T) {
+	signer, verifier, _ := newKeys(t)
+	payload := []byte(`{"Producers": []}`)
+	scope := TopicScope("p2p")
+	verifier.MaxAge = time.Hour
+
+	signedAt := time.Now().Add(-2 * time.Hour).UTC()
+	replayed, _ := json.Marshal(Envelope{
+		Payload:   payload,
+		Scope:     scope,
+		SignedAt:  signedAt,
+		Signature: signer.Sign(scope, signedAt, payload),
+	})
+	_, _, err := verifier.Open(scope, replayed)
+	require.Equal(t, ErrExpired, err)
+
+	// a fresh signing time cannot be grafted on the replayed signature
+	envelope, _ := ParseEnvelope(replayed)
+	envelope.SignedAt = time.Now().UTC()
+	tampered, _ := json.Marshal(envelope)
+	_, _, err = verifier.Open(scope, tampered)
+	require.Equal(t, ErrBadSignature, err)
+
+	verifier.MaxAge = 0
+	_, _, err = verifier.Open(scope, replayed)
+	require.NoError(t, err)
+}
+
+func TestLoadInvalidKeys(t *testing.T) {
+	_, _, pubFile := newKeys(t)
+	_, err := LoadSigner(pubFile)
+	require.Error(t, err)
+	_, err = LoadVerifier(filepath.Join(t.TempDir(), "missing.pub"))
+	require.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/sign"
+)
+
+// bodyRecorder buffers a response so that it can be signed before being sent
+type bodyRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bodyRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *bodyRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *bodyRecorder) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
+
+// signHandler adds the detached signature of the response body in the sign.Header header,
+// along with its signing time, health checks and metrics scrapes excepted. The signature
+// covers the magic query parameter, so that a response cannot be replayed for another network.
+func signHandler(signer *sign.Signer, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "/health" || route == "/metrics" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		rec := &bodyRecorder{header: w.Header(), status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		signedAt := time.Now().UTC()
+		scope := sign.MagicScope(r.URL.Query().Get("magic"))
+		w.Header().Set(sign.SignedAtHeader, signedAt.Format(time.RFC3339Nano))
+		w.Header().Set(sign.Header, signer.Sign(scope, signedAt, rec.body.Bytes()))
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
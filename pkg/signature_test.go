@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/pkg/sign"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignHandler(t *testing.T) {
+	privPem, pubPem, err := sign.GenerateKey()
+	require.NoError(t, err)
+	priv, err := sign.ParsePrivateKey(privPem)
+	require.NoError(t, err)
+	pub, err := sign.ParsePublicKey(pubPem)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	mux.HandleFunc("/htopology/v1/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(203)
+		fmt.Fprint(w, `{"resultcode": "203"}`)
+	})
+	handler := signHandler(sign.NewSigner(priv), mux)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/htopology/v1/?magic=1&port=3001", nil))
+	require.Equal(t, 203, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	signedAt, err := time.Parse(time.RFC3339Nano, rec.Header().Get(sign.SignedAtHeader))
+	require.NoError(t, err)
+	_, err = sign.NewVerifier(pub).Verify(sign.MagicScope("1"), signedAt, rec.Body.Bytes(), rec.Header().Get(sign.Header))
+	require.NoError(t, err)
+	_, err = sign.NewVerifier(pub).Verify(sign.MagicScope("2"), signedAt, rec.Body.Bytes(), rec.Header().Get(sign.Header))
+	require.Equal(t, sign.ErrBadSignature, err)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, 200, rec.Code)
+	require.Empty(t, rec.Header().Get(sign.Header))
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"sort"
+)
+
+const PeerSnapshotVersion = 1
+
+// SnapshotRelay is a relay access point of a big ledger pool
+type SnapshotRelay struct {
+	Address string `json:"address,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	Port    int    `json:"port"`
+}
+
+// BigLedgerPool is a pool listed in a peer snapshot
+type BigLedgerPool struct {
+	PoolId           string          `json:"-"`
+	AccumulatedStake float64         `json:"accumulatedStake"`
+	RelativeStake    float64         `json:"relativeStake"`
+	Relays           []SnapshotRelay `json:"relays"`
+}
+
+// PeerSnapshot is the peer-snapshot.json file used by Ouroboros Genesis nodes
+type PeerSnapshot struct {
+	BigLedgerPools []BigLedgerPool `json:"bigLedgerPools"`
+	SlotNo         uint64          `json:"slotNo"`
+	Version        int             `json:"version"`
+}
+
+func snapshotRelays(relays []PoolRelay) []SnapshotRelay {
+	out := make([]SnapshotRelay, 0, len(relays))
+	for _, relay := range relays {
+		addr, family := relayAddr(relay)
+		switch family {
+		case FamilyIpv4, FamilyIpv6:
+			out = append(out, SnapshotRelay{Address: addr, Port: relay.Port})
+		case FamilyHostname:
+			out = append(out, SnapshotRelay{Domain: addr, Port: relay.Port})
+		}
+	}
+	return out
+}
+
+// NewPeerSnapshot returns the big ledger pools among the vetted and served pools:
+// pools sorted by decreasing stake until their accumulated stake reaches stakePercent
+// of the total stake.
+func NewPeerSnapshot(reports []*PoolReport, slot uint64, stakePercent float64) *PeerSnapshot {
+	pools := make([]*PoolReport, 0, len(reports))
+	for _, report := range reports {
+		if report.Parameters == nil || len(report.Parameters.Relays) == 0 {
+			continue
+		}
+		if report.Verdict == VerdictVetted || report.Verdict == VerdictServed {
+			pools = append(pools, report)
+		}
+	}
+	sort.SliceStable(pools, func(i, j int) bool {
+		if pools[i].Stake == pools[j].Stake {
+			return pools[i].PoolId < pools[j].PoolId
+		}
+		return pools[i].Stake > pools[j].Stake
+	})
+	snapshot := &PeerSnapshot{
+		BigLedgerPools: make([]BigLedgerPool, 0),
+		SlotNo:         slot,
+		Version:        PeerSnapshotVersion,
+	}
+	accumulated := 0.0
+	for _, pool := range pools {
+		if accumulated >= stakePercent/100 {
+			break
+		}
+		accumulated += pool.Stake
+		snapshot.BigLedgerPools = append(snapshot.BigLedgerPools, BigLedgerPool{
+			PoolId:           pool.PoolId,
+			AccumulatedStake: accumulated,
+			RelativeStake:    pool.Stake,
+			Relays:           snapshotRelays(pool.Parameters.Relays),
+		})
+	}
+	return snapshot
+}
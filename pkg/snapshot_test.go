@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sampleReport(poolId string, stake float64, verdict Verdict) *PoolReport {
+	addr := "10.0.0.1"
+	host := "relay." + poolId + ".example.com"
+	return &PoolReport{
+		PoolId:  poolId,
+		Stake:   stake,
+		Verdict: verdict,
+		Parameters: &PoolParameters{
+			Id:     poolId,
+			Relays: []PoolRelay{{Port: 3001, Ip4: &addr}, {Port: 3002, HostName: &host}},
+		},
+	}
+}
+
+func TestPeerSnapshot(t *testing.T) {
+	reports := []*PoolReport{
+		sampleReport("pool1small", 0.1, VerdictServed),
+		sampleReport("pool1big", 0.5, VerdictServed),
+		sampleReport("pool1down", 0.3, VerdictProbeFailed),
+		sampleReport("pool1medium", 0.25, VerdictVetted),
+		sampleReport("pool1tiny", 0.05, VerdictServed),
+	}
+	snapshot := NewPeerSnapshot(reports, 1234, 80)
+	require.EqualValues(t, 1234, snapshot.SlotNo)
+	require.Equal(t, PeerSnapshotVersion, snapshot.Version)
+	require.Len(t, snapshot.BigLedgerPools, 3)
+	require.Equal(t, "pool1big", snapshot.BigLedgerPools[0].PoolId)
+	require.Equal(t, "pool1medium", snapshot.BigLedgerPools[1].PoolId)
+	require.Equal(t, "pool1small", snapshot.BigLedgerPools[2].PoolId)
+	require.InDelta(t, 0.85, snapshot.BigLedgerPools[2].AccumulatedStake, 1e-9)
+
+	out, err := json.Marshal(snapshot.BigLedgerPools[0].Relays)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"address": "10.0.0.1", "port": 3001}, {"domain": "relay.pool1big.example.com", "port": 3002}]`, string(out))
+}
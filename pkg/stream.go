@@ -0,0 +1,277 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/regel/cardano-p2p/log"
+)
+
+const (
+	streamTopologyField = "topology"
+	streamDigestField   = "sha256"
+	streamMaxLen        = 100
+	streamBlock         = 5 * time.Second
+	streamReadCount     = 10
+)
+
+// ErrTopologyRejected is wrapped by the errors of apply functions refusing a topology,
+// eg. a bad signature or an invalid topology. Rejected topologies are acknowledged
+// so that they are not delivered again, but they are not recorded as applied.
+var ErrTopologyRejected = errors.New("topology rejected")
+
+// TopologyMessage is a topology published on a Redis stream.
+// Its version is the id of the stream entry.
+type TopologyMessage struct {
+	Version  string
+	Topology []byte
+	Digest   string
+}
+
+// TopologyAck records the last topology version applied by a consumer
+type TopologyAck struct {
+	Group     string    `json:"group"`
+	Consumer  string    `json:"consumer"`
+	Version   string    `json:"version"`
+	Digest    string    `json:"sha256"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// TopologyStream publishes topologies on a Redis stream and consumes them with consumer groups,
+// so that consumers that were down receive the updates they missed
+type TopologyStream struct {
+	client *redis.Client
+	stream string
+}
+
+// NewTopologyStream returns the topology stream named stream
+func NewTopologyStream(client *redis.Client, stream string) *TopologyStream {
+	return &TopologyStream{client: client, stream: stream}
+}
+
+func (s *TopologyStream) acksKey() string {
+	return s.stream + ":acks"
+}
+
+func topologyDigest(topology []byte) string {
+	sum := sha256.Sum256(topology)
+	return hex.EncodeToString(sum[:])
+}
+
+// Publish appends topology to the stream and returns its version
+func (s *TopologyStream) Publish(ctx context.Context, topology []byte) (string, error) {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			streamTopologyField: string(topology),
+			streamDigestField:   topologyDigest(topology),
+		},
+	}).Result()
+}
+
+func newTopologyMessage(msg redis.XMessage) (*TopologyMessage, error) {
+	topology, ok := msg.Values[streamTopologyField].(string)
+	if !ok {
+		return nil, fmt.Errorf("entry %s has no %s field", msg.ID, streamTopologyField)
+	}
+	digest := topologyDigest([]byte(topology))
+	if expected, ok := msg.Values[streamDigestField].(string); ok && expected != digest {
+		return nil, fmt.Errorf("entry %s: sha256 mismatch", msg.ID)
+	}
+	return &TopologyMessage{Version: msg.ID, Topology: []byte(topology), Digest: digest}, nil
+}
+
+// Latest returns the last published topology, or nil if the stream is empty
+func (s *TopologyStream) Latest(ctx context.Context) (*TopologyMessage, error) {
+	msgs, err := s.client.XRevRangeN(ctx, s.stream, "+", "-", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	return newTopologyMessage(msgs[0])
+}
+
+// Acks returns the last version applied by each consumer
+func (s *TopologyStream) Acks(ctx context.Context) ([]TopologyAck, error) {
+	values, err := s.client.HGetAll(ctx, s.acksKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	acks := make([]TopologyAck, 0, len(values))
+	for _, value := range values {
+		var ack TopologyAck
+		if err := json.Unmarshal([]byte(value), &ack); err != nil {
+			return nil, err
+		}
+		acks = append(acks, ack)
+	}
+	sort.Slice(acks, func(i, j int) bool { return ackKey(acks[i]) < ackKey(acks[j]) })
+	return acks, nil
+}
+
+func ackKey(ack TopologyAck) string {
+	return ack.Group + "/" + ack.Consumer
+}
+
+func (s *TopologyStream) ack(ctx context.Context, group string, consumer string, msg *TopologyMessage, ids []string) error {
+	if len(ids) > 0 {
+		if err := s.client.XAck(ctx, s.stream, group, ids...).Err(); err != nil {
+			return err
+		}
+	}
+	if msg == nil {
+		return nil
+	}
+	ack := TopologyAck{
+		Group:     group,
+		Consumer:  consumer,
+		Version:   msg.Version,
+		Digest:    msg.Digest,
+		AppliedAt: time.Now().UTC(),
+	}
+	data, _ := json.Marshal(ack)
+	return s.client.HSet(ctx, s.acksKey(), ackKey(ack), string(data)).Err()
+}
+
+// compareVersions compares two stream entry ids, formatted as <milliseconds>-<sequence>
+func compareVersions(a string, b string) int {
+	am, as := splitVersion(a)
+	bm, bs := splitVersion(b)
+	switch {
+	case am < bm:
+		return -1
+	case am > bm:
+		return 1
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	}
+	return 0
+}
+
+func splitVersion(version string) (uint64, uint64) {
+	parts := strings.SplitN(version, "-", 2)
+	ms, _ := strconv.ParseUint(parts[0], 10, 64)
+	var seq uint64
+	if len(parts) == 2 {
+		seq, _ = strconv.ParseUint(parts[1], 10, 64)
+	}
+	return ms, seq
+}
+
+// Consume creates the consumer group if needed, applies the latest topology,
+// then applies new topologies until ctx is done. When several topologies are read at once,
+// only the most recent one is applied. Entries are acknowledged once applied,
+// and entries older than the applied version are acknowledged without being applied.
+// Entries that fail to apply are left pending and retried on the next call to Consume,
+// unless apply rejected them with ErrTopologyRejected.
+func (s *TopologyStream) Consume(ctx context.Context, group string, consumer string, apply func(*TopologyMessage) error) error {
+	err := s.client.XGroupCreateMkStream(ctx, s.stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("cannot create consumer group: %v", err)
+	}
+	latest, err := s.Latest(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot read latest topology: %v", err)
+	}
+	var applied string
+	if latest != nil {
+		if err := apply(latest); errors.Is(err, ErrTopologyRejected) {
+			log.Errorf("Skipping rejected topology %s", latest.Version)
+		} else if err != nil {
+			return err
+		} else {
+			applied = latest.Version
+			if err := s.ack(ctx, group, consumer, latest, nil); err != nil {
+				return err
+			}
+		}
+	}
+	// entries delivered to this consumer but not acknowledged before a restart come first
+	start := "0"
+	for ctx.Err() == nil {
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{s.stream, start},
+			Count:    streamReadCount,
+			Block:    streamBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var msgs []redis.XMessage
+		for _, stream := range streams {
+			msgs = append(msgs, stream.Messages...)
+		}
+		if len(msgs) == 0 && start == "0" {
+			start = ">"
+			continue
+		}
+		applied, err = s.consume(ctx, group, consumer, msgs, applied, apply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TopologyStream) consume(ctx context.Context, group string, consumer string, msgs []redis.XMessage, applied string, apply func(*TopologyMessage) error) (string, error) {
+	ids := make([]string, 0, len(msgs))
+	for i := range msgs {
+		ids = append(ids, msgs[i].ID)
+	}
+	applied, msg, err := applyNewest(msgs, applied, apply)
+	if err != nil {
+		return applied, err
+	}
+	return applied, s.ack(ctx, group, consumer, msg, ids)
+}
+
+// applyNewest applies the newest of msgs that is more recent than the applied version.
+// It returns the applied version and the message to record as applied,
+// nil if no message was applied because they were older, invalid or rejected.
+func applyNewest(msgs []redis.XMessage, applied string, apply func(*TopologyMessage) error) (string, *TopologyMessage, error) {
+	var newest *redis.XMessage
+	for i := range msgs {
+		if applied == "" || compareVersions(msgs[i].ID, applied) > 0 {
+			if newest == nil || compareVersions(msgs[i].ID, newest.ID) > 0 {
+				newest = &msgs[i]
+			}
+		}
+	}
+	if newest == nil {
+		return applied, nil, nil
+	}
+	msg, err := newTopologyMessage(*newest)
+	if err != nil {
+		log.Errorf("Skipping invalid topology: %v", err)
+		return applied, nil, nil
+	}
+	if err := apply(msg); errors.Is(err, ErrTopologyRejected) {
+		log.Errorf("Skipping rejected topology %s", msg.Version)
+		return applied, nil, nil
+	} else if err != nil {
+		return applied, nil, err
+	}
+	return msg.Version, msg, nil
+}
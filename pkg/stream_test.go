@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	require.Equal(t, 0, compareVersions("1644000000000-0", "1644000000000-0"))
+	require.Equal(t, -1, compareVersions("1644000000000-0", "1644000000000-1"))
+	require.Equal(t, 1, compareVersions("1644000000001-0", "1644000000000-9"))
+	require.Equal(t, 1, compareVersions("10000000000000-0", "9000000000000-0"))
+	require.Equal(t, -1, compareVersions("0-0", "1-0"))
+}
+
+func TestNewTopologyMessage(t *testing.T) {
+	topology := `{"Producers": []}`
+	msg, err := newTopologyMessage(redis.XMessage{
+		ID: "1644000000000-0",
+		Values: map[string]interface{}{
+			streamTopologyField: topology,
+			streamDigestField:   topologyDigest([]byte(topology)),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1644000000000-0", msg.Version)
+	require.Equal(t, topology, string(msg.Topology))
+
+	_, err = newTopologyMessage(redis.XMessage{
+		ID: "1644000000000-1",
+		Values: map[string]interface{}{
+			streamTopologyField: topology,
+			streamDigestField:   "00",
+		},
+	})
+	require.Error(t, err)
+
+	_, err = newTopologyMessage(redis.XMessage{ID: "1644000000000-2", Values: map[string]interface{}{}})
+	require.Error(t, err)
+}
+
+func TestApplyNewestRejected(t *testing.T) {
+	topology := `{"Producers": []}`
+	entry := func(id string) redis.XMessage {
+		return redis.XMessage{ID: id, Values: map[string]interface{}{streamTopologyField: topology}}
+	}
+	msgs := []redis.XMessage{entry("1644000000000-0"), entry("1644000000001-0")}
+
+	var versions []string
+	applied, msg, err := applyNewest(msgs, "1644000000000-0", func(m *TopologyMessage) error {
+		versions = append(versions, m.Version)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1644000000001-0", applied)
+	require.Equal(t, "1644000000001-0", msg.Version)
+	require.Equal(t, []string{"1644000000001-0"}, versions)
+
+	applied, msg, err = applyNewest(msgs, "1644000000000-0", func(m *TopologyMessage) error {
+		return fmt.Errorf("%w: bad signature", ErrTopologyRejected)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1644000000000-0", applied)
+	require.Nil(t, msg)
+
+	_, _, err = applyNewest(msgs, "", func(m *TopologyMessage) error {
+		return fmt.Errorf("cannot write topology")
+	})
+	require.Error(t, err)
+
+	applied, msg, err = applyNewest(msgs, "1644000000001-0", func(m *TopologyMessage) error {
+		t.Fatal("older versions must not be applied")
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1644000000001-0", applied)
+	require.Nil(t, msg)
+}
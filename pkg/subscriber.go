@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SubscriberState is the health of a topology subscriber
+type SubscriberState struct {
+	Mode          string     `json:"mode"`
+	Topic         string     `json:"topic"`
+	Connected     bool       `json:"connected"`
+	Reconnects    int        `json:"reconnects"`
+	LastMessageAt *time.Time `json:"lastMessageAt,omitempty"`
+	LastAppliedAt *time.Time `json:"lastAppliedAt,omitempty"`
+	LastVersion   string     `json:"lastVersion,omitempty"`
+	Rejected      int        `json:"rejected"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// SubscriberStatus tracks the state of a topology subscriber and serves it over http
+type SubscriberStatus struct {
+	mu    sync.Mutex
+	state SubscriberState
+}
+
+// NewSubscriberStatus returns the status of a subscriber of topic
+func NewSubscriberStatus(mode string, topic string) *SubscriberStatus {
+	return &SubscriberStatus{state: SubscriberState{Mode: mode, Topic: topic}}
+}
+
+// Connected records a successful (re)connection
+func (s *SubscriberStatus) Connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Connected = true
+}
+
+// Disconnected records the error that ended a connection
+func (s *SubscriberStatus) Disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Connected {
+		s.state.Reconnects++
+	}
+	s.state.Connected = false
+	if err != nil {
+		s.state.LastError = err.Error()
+	}
+}
+
+// Received records a topology message
+func (s *SubscriberStatus) Received() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.state.LastMessageAt = &now
+}
+
+// Applied records a topology written to the output file
+func (s *SubscriberStatus) Applied(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.state.LastAppliedAt = &now
+	s.state.LastVersion = version
+}
+
+// Rejected records an invalid topology
+func (s *SubscriberStatus) Rejected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Rejected++
+	s.state.LastError = err.Error()
+}
+
+// State returns a copy of the current state
+func (s *SubscriberStatus) State() SubscriberState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// ServeHTTP serves /health, which fails while disconnected from Redis, and /status
+func (s *SubscriberStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := s.State()
+	switch r.URL.Path {
+	case "/health":
+		if !state.Connected {
+			writeError(w, http.StatusServiceUnavailable, "disconnected")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "/status":
+		writeJSON(w, http.StatusOK, state)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriberStatus(t *testing.T) {
+	status := NewSubscriberStatus("stream", "p2p")
+	get := func(path string) (int, SubscriberState) {
+		rec := httptest.NewRecorder()
+		status.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var state SubscriberState
+		_ = json.Unmarshal(rec.Body.Bytes(), &state)
+		return rec.Code, state
+	}
+
+	code, _ := get("/health")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+
+	status.Connected()
+	status.Received()
+	status.Applied("1644000000000-0")
+	status.Received()
+	status.Rejected(errors.New("invalid json"))
+	code, _ = get("/health")
+	require.Equal(t, http.StatusOK, code)
+
+	status.Disconnected(errors.New("connection reset"))
+	status.Connected()
+	code, state := get("/status")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, state.Connected)
+	require.Equal(t, 1, state.Reconnects)
+	require.Equal(t, 1, state.Rejected)
+	require.Equal(t, "1644000000000-0", state.LastVersion)
+	require.Equal(t, "connection reset", state.LastError)
+	require.NotNil(t, state.LastAppliedAt)
+
+	code, _ = get("/nope")
+	require.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	TipSourceOgmios     = "ogmios"
+	TipSourcePrometheus = "prometheus"
+	TipSourceEKG        = "ekg"
+
+	// blockNumMetric is the cardano-node metric holding the block number of the tip
+	blockNumMetric = "cardano_node_metrics_blockNum_int"
+)
+
+// TipProvider returns the block number of the tip of our Cardano node
+type TipProvider interface {
+	BlockNo(ctx context.Context) (int64, error)
+}
+
+// NewTipProvider returns the provider of the given source reading the tip at url
+func NewTipProvider(source string, url string) (TipProvider, error) {
+	switch source {
+	case TipSourceOgmios:
+		return NewOgmiosTipProvider(url), nil
+	case TipSourcePrometheus:
+		return NewPrometheusTipProvider(url), nil
+	case TipSourceEKG:
+		return NewEKGTipProvider(url), nil
+	default:
+		return nil, fmt.Errorf("unknown tip source '%s'", source)
+	}
+}
+
+type ogmiosTipProvider struct {
+	url string
+}
+
+// NewOgmiosTipProvider returns a TipProvider querying the block height from an Ogmios websocket
+func NewOgmiosTipProvider(url string) TipProvider {
+	return &ogmiosTipProvider{url: url}
+}
+
+func (p *ogmiosTipProvider) BlockNo(ctx context.Context) (int64, error) {
+	blockNo, err := GetBlockHeightContext(ctx, p.url)
+	if err != nil {
+		return 0, err
+	}
+	if blockNo == nil {
+		return 0, fmt.Errorf("no block height at '%s'", p.url)
+	}
+	return *blockNo, nil
+}
+
+func getMetrics(ctx context.Context, url string, accept string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestMaxWaitTime)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Cannot create request: %v", err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("Get '%s': %s", url, resp.Status)
+	}
+	return &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+type prometheusTipProvider struct {
+	url string
+}
+
+// NewPrometheusTipProvider returns a TipProvider reading the cardano_node_metrics_blockNum_int
+// metric of cardano-node's Prometheus endpoint, eg. http://localhost:12798/metrics
+func NewPrometheusTipProvider(url string) TipProvider {
+	return &prometheusTipProvider{url: url}
+}
+
+func (p *prometheusTipProvider) BlockNo(ctx context.Context) (int64, error) {
+	body, err := getMetrics(ctx, p.url, "text/plain")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return parsePrometheusBlockNo(io.LimitReader(body, maxMetricsLen))
+}
+
+func parsePrometheusBlockNo(r io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, blockNumMetric) {
+			continue
+		}
+		rest := strings.TrimPrefix(line, blockNumMetric)
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return 0, fmt.Errorf("invalid metric line '%s'", line)
+			}
+			rest = rest[end+1:]
+		} else if !strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, "\t") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return 0, fmt.Errorf("invalid metric line '%s'", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid metric line '%s': %v", line, err)
+		}
+		return int64(value), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("metric %s not found", blockNumMetric)
+}
+
+type ekgTipProvider struct {
+	url string
+}
+
+// NewEKGTipProvider returns a TipProvider reading the blockNum metric
+// of cardano-node's EKG endpoint, eg. http://localhost:12788
+func NewEKGTipProvider(url string) TipProvider {
+	return &ekgTipProvider{url: url}
+}
+
+// ekgMetrics is the part of the EKG JSON document holding cardano-node metrics
+type ekgMetrics struct {
+	Cardano struct {
+		Node struct {
+			Metrics struct {
+				BlockNum struct {
+					Int *struct {
+						Val int64 `json:"val"`
+					} `json:"int"`
+				} `json:"blockNum"`
+			} `json:"metrics"`
+		} `json:"node"`
+	} `json:"cardano"`
+}
+
+func (p *ekgTipProvider) BlockNo(ctx context.Context) (int64, error) {
+	body, err := getMetrics(ctx, p.url, "application/json")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	var metrics ekgMetrics
+	if err := json.NewDecoder(io.LimitReader(body, maxMetricsLen)).Decode(&metrics); err != nil {
+		return 0, fmt.Errorf("Unmarshal error: %v", err)
+	}
+	blockNum := metrics.Cardano.Node.Metrics.BlockNum.Int
+	if blockNum == nil {
+		return 0, fmt.Errorf("metric cardano.node.metrics.blockNum not found")
+	}
+	return blockNum.Val, nil
+}
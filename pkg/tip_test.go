@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const samplePrometheusMetrics = `# TYPE cardano_node_metrics_blockNum_int_total counter
+cardano_node_metrics_blockNum_int_total 42
+# TYPE cardano_node_metrics_blockNum_int gauge
+cardano_node_metrics_blockNum_int 7012345
+cardano_node_metrics_slotNum_int 54321987
+`
+
+const sampleEKGMetrics = `{
+  "cardano": {
+    "node": {
+      "metrics": {
+        "blockNum": {"int": {"type": "g", "val": 7012345}},
+        "slotNum": {"int": {"type": "g", "val": 54321987}}
+      }
+    }
+  }
+}`
+
+func TestParsePrometheusBlockNo(t *testing.T) {
+	blockNo, err := parsePrometheusBlockNo(strings.NewReader(samplePrometheusMetrics))
+	require.NoError(t, err)
+	require.Equal(t, int64(7012345), blockNo)
+
+	blockNo, err = parsePrometheusBlockNo(strings.NewReader(`cardano_node_metrics_blockNum_int{instance="relay1"} 1.2e+06`))
+	require.NoError(t, err)
+	require.Equal(t, int64(1200000), blockNo)
+
+	_, err = parsePrometheusBlockNo(strings.NewReader("cardano_node_metrics_slotNum_int 54321987\n"))
+	require.Error(t, err)
+	_, err = parsePrometheusBlockNo(strings.NewReader("cardano_node_metrics_blockNum_int NaN-ish\n"))
+	require.Error(t, err)
+}
+
+func TestTipProviders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			fmt.Fprint(w, samplePrometheusMetrics)
+		case "/":
+			require.Equal(t, "application/json", r.Header.Get("Accept"))
+			fmt.Fprint(w, sampleEKGMetrics)
+		case "/empty":
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		source string
+		url    string
+	}{
+		{TipSourcePrometheus, ts.URL + "/metrics"},
+		{TipSourceEKG, ts.URL + "/"},
+	} {
+		provider, err := NewTipProvider(tc.source, tc.url)
+		require.NoError(t, err)
+		blockNo, err := provider.BlockNo(ctx)
+		require.NoError(t, err, tc.source)
+		require.Equal(t, int64(7012345), blockNo, tc.source)
+	}
+
+	_, err := NewEKGTipProvider(ts.URL + "/empty").BlockNo(ctx)
+	require.Error(t, err)
+	_, err = NewPrometheusTipProvider(ts.URL + "/missing").BlockNo(ctx)
+	require.Error(t, err)
+	_, err = NewTipProvider("carrier-pigeon", ts.URL)
+	require.Error(t, err)
+}
+
+func TestOgmiosTipProvider(t *testing.T) {
+	ogmios := newOgmiosServer(t, map[string]PoolParameters{})
+	defer ogmios.Close()
+
+	provider, err := NewTipProvider(TipSourceOgmios, "ws"+strings.TrimPrefix(ogmios.URL, "http"))
+	require.NoError(t, err)
+	blockNo, err := provider.BlockNo(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1234), blockNo)
+}
+
+func TestOgmiosTipProviderTimeout(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	release := make(chan struct{})
+	defer close(release)
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer ws.Close()
+		<-release
+	}))
+	defer stalled.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewOgmiosTipProvider("ws"+strings.TrimPrefix(stalled.URL, "http")).BlockNo(ctx)
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
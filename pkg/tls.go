@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/regel/cardano-p2p/log"
+	"github.com/regel/cardano-p2p/server"
+)
+
+// certReloader serves the certificate, key and client CA bundle found on disk
+// and reloads them whenever one of the files is modified.
+type certReloader struct {
+	config *server.TLSConfig
+
+	mu      sync.Mutex
+	modTime map[string]time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newCertReloader(config *server.TLSConfig) (*certReloader, error) {
+	r := &certReloader{
+		config:  config,
+		modTime: make(map[string]time.Time),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// changed returns true if any file has a modification time different
+// from the one seen at the last reload.
+func (r *certReloader) changed() bool {
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTime[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) reload() error {
+	modTime := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return fmt.Errorf("cannot stat '%s': %v", f, err)
+		}
+		modTime[f] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load key pair: %v", err)
+	}
+	var pool *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("cannot read client CA bundle: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in '%s'", r.config.ClientCAFile)
+		}
+	}
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	return nil
+}
+
+// current returns the certificate and client CA pool, reloading them from
+// disk first if needed. The previous values are kept if reloading fails.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.changed() {
+		if err := r.reload(); err != nil {
+			log.Errorf("tls reload failed, keeping previous certificates: %v", err)
+		} else {
+			log.Infof("tls certificates reloaded from '%s'", r.config.CertFile)
+		}
+	}
+	return r.cert, r.pool
+}
+
+// clientAuth verifies client certificates if given. Public routes stay reachable
+// without a certificate, private routes are guarded by requireClientCert.
+func (r *certReloader) clientAuth() tls.ClientAuthType {
+	if r.config.ClientCAFile == "" {
+		return tls.NoClientCert
+	}
+	return tls.VerifyClientCertIfGiven
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, pool := r.current()
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+		ClientCAs:    pool,
+		ClientAuth:   r.clientAuth(),
+	}, nil
+}
+
+// requireClientCert rejects requests without a client certificate verified against
+// the client CA bundle, if private is true and a client CA bundle is configured
+func requireClientCert(config *server.ServerConfig, private bool, next http.Handler) http.Handler {
+	if !private || config.TLS.ClientCAFile == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			writeError(w, http.StatusUnauthorized, "client certificate required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewTLSConfig returns a server side tls.Config for the given configuration.
+// Certificates and the client CA bundle are reloaded from disk on change.
+func NewTLSConfig(config *server.TLSConfig) (*tls.Config, error) {
+	r, err := newCertReloader(config)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
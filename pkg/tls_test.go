@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/regel/cardano-p2p/server"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyPair(t *testing.T, dir string, cn string, modTime time.Time) *server.TLSConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	config := &server.TLSConfig{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	require.NoError(t, ioutil.WriteFile(config.CertFile, certPem, 0600))
+	require.NoError(t, ioutil.WriteFile(config.KeyFile, keyPem, 0600))
+	require.NoError(t, os.Chtimes(config.CertFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(config.KeyFile, modTime, modTime))
+	return config
+}
+
+func commonName(t *testing.T, r *certReloader) string {
+	cert, _ := r.current()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloaderReloadsOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	config := writeKeyPair(t, dir, "first", now.Add(-time.Minute))
+	r, err := newCertReloader(config)
+	require.NoError(t, err)
+	require.Equal(t, "first", commonName(t, r))
+
+	writeKeyPair(t, dir, "second", now)
+	require.Equal(t, "second", commonName(t, r))
+}
+
+func TestCertReloaderKeepsPreviousOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := writeKeyPair(t, dir, "first", time.Now().Add(-time.Minute))
+	r, err := newCertReloader(config)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(config.KeyFile, []byte("garbage"), 0600))
+	require.Equal(t, "first", commonName(t, r))
+}
+
+func TestCertReloaderClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := writeKeyPair(t, dir, "first", time.Now())
+	config.ClientCAFile = config.CertFile
+	config.RequireClientCert = true
+	tlsConfig, err := NewTLSConfig(config)
+	require.NoError(t, err)
+	c, err := tlsConfig.GetConfigForClient(nil)
+	require.NoError(t, err)
+	require.NotNil(t, c.ClientCAs)
+	require.Equal(t, tls.VerifyClientCertIfGiven, c.ClientAuth)
+}
+
+func TestPrivateRoutesRequireClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := server.DefaultConfig()
+	config.Server.TLS = *writeKeyPair(t, dir, "localhost", time.Now())
+	config.Server.TLS.ClientCAFile = config.Server.TLS.CertFile
+	tlsConfig, err := NewTLSConfig(&config.Server.TLS)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(newServeMux(&config.Server, sampleNetworks(nil, nil), nil))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(client *http.Client, path string) int {
+		resp, err := client.Get(ts.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	require.Equal(t, http.StatusOK, get(anonymous, "/health"))
+	require.Equal(t, http.StatusOK, get(anonymous, "/api/v2/peers"))
+	require.Equal(t, http.StatusUnauthorized, get(anonymous, "/api/v2/snapshot"))
+
+	cert, err := tls.LoadX509KeyPair(config.Server.TLS.CertFile, config.Server.TLS.KeyFile)
+	require.NoError(t, err)
+	authenticated := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{cert},
+	}}}
+	require.Equal(t, http.StatusOK, get(authenticated, "/api/v2/snapshot"))
+}
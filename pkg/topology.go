@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+)
+
+// volatileTopologyKeys are the fields of a legacy topology that change on every fetch
+var volatileTopologyKeys = []string{"resultcode", "datetime", "clientIp", "msg"}
+
+// AccessPoint is the address of a peer in the P2P topology format
+type AccessPoint struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+// LocalRootGroup is a group of peers the node always tries to stay connected to
+type LocalRootGroup struct {
+	AccessPoints []AccessPoint `json:"accessPoints"`
+	Advertise    bool          `json:"advertise"`
+	Trustable    bool          `json:"trustable"`
+	Valency      int           `json:"valency"`
+	HotValency   int           `json:"hotValency"`
+}
+
+// PublicRootGroup is a group of peers used to discover the network
+type PublicRootGroup struct {
+	AccessPoints []AccessPoint `json:"accessPoints"`
+	Advertise    bool          `json:"advertise"`
+}
+
+// P2PTopology is the topology file format of cardano-node releases running in P2P mode
+type P2PTopology struct {
+	BootstrapPeers     []AccessPoint     `json:"bootstrapPeers"`
+	LocalRoots         []LocalRootGroup  `json:"localRoots"`
+	PublicRoots        []PublicRootGroup `json:"publicRoots"`
+	UseLedgerAfterSlot int64             `json:"useLedgerAfterSlot"`
+}
+
+func accessPoints(producers []Producer) []AccessPoint {
+	out := make([]AccessPoint, 0, len(producers))
+	for _, p := range producers {
+		out = append(out, AccessPoint{
+			Address: p.Addr,
+			Port:    p.Port,
+		})
+	}
+	return out
+}
+
+// NewP2PTopology returns a P2P topology with the fetched producers as public roots
+// and the custom producers as one trustable local root group. The valency of the
+// group is the sum of the custom valencies, counting one per IP address since only
+// DNS names can resolve to several peers.
+// Bootstrap peers are disabled if bootstrap is nil, and ledger peers
+// are disabled if useLedgerAfterSlot is negative.
+func NewP2PTopology(producers []Producer, custom []Producer, bootstrap []Producer, useLedgerAfterSlot int64) *P2PTopology {
+	t := &P2PTopology{
+		LocalRoots:         make([]LocalRootGroup, 0),
+		PublicRoots:        make([]PublicRootGroup, 0),
+		UseLedgerAfterSlot: useLedgerAfterSlot,
+	}
+	if bootstrap != nil {
+		t.BootstrapPeers = accessPoints(bootstrap)
+	}
+	if len(custom) > 0 {
+		valency := 0
+		for _, p := range custom {
+			if p.Valency > 1 && net.ParseIP(p.Addr) == nil {
+				valency += p.Valency
+			} else {
+				valency++
+			}
+		}
+		t.LocalRoots = append(t.LocalRoots, LocalRootGroup{
+			AccessPoints: accessPoints(custom),
+			Advertise:    false,
+			Trustable:    true,
+			Valency:      valency,
+			HotValency:   valency,
+		})
+	}
+	if len(producers) > 0 {
+		t.PublicRoots = append(t.PublicRoots, PublicRootGroup{
+			AccessPoints: accessPoints(producers),
+			Advertise:    false,
+		})
+	}
+	return t
+}
+
+// ParseTopologyProducers returns the producers of a legacy topology file,
+// or the public roots of a P2P topology file
+func ParseTopologyProducers(data []byte) ([]Producer, error) {
+	var topology struct {
+		Producers   []Producer        `json:"Producers"`
+		PublicRoots []PublicRootGroup `json:"publicRoots"`
+	}
+	if err := json.Unmarshal(data, &topology); err != nil {
+		return nil, err
+	}
+	out := topology.Producers
+	for _, group := range topology.PublicRoots {
+		for _, ap := range group.AccessPoints {
+			out = append(out, Producer{
+				Addr:    ap.Address,
+				Port:    ap.Port,
+				Valency: 1,
+			})
+		}
+	}
+	return out, nil
+}
+
+// TopologyChanged returns true if filename does not already contain the topology of data.
+// The volatile fields of legacy topologies and the order of producers and access points
+// are ignored, files that are not JSON are compared as is.
+func TopologyChanged(filename string, data []byte) bool {
+	current, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return true
+	}
+	normalizedCurrent, err := normalizeTopology(current)
+	if err != nil {
+		return !bytes.Equal(current, data)
+	}
+	normalized, err := normalizeTopology(data)
+	if err != nil {
+		return !bytes.Equal(current, data)
+	}
+	return !bytes.Equal(normalizedCurrent, normalized)
+}
+
+// normalizeTopology returns the topology of data without its volatile fields
+// and with its lists sorted
+func normalizeTopology(data []byte) ([]byte, error) {
+	var topology map[string]interface{}
+	if err := json.Unmarshal(data, &topology); err != nil {
+		return nil, err
+	}
+	for _, key := range volatileTopologyKeys {
+		delete(topology, key)
+	}
+	sortLists(topology)
+	return json.Marshal(topology)
+}
+
+// sortLists sorts the lists found in v by their JSON encoding
+func sortLists(v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			sortLists(child)
+		}
+	case []interface{}:
+		for _, child := range value {
+			sortLists(child)
+		}
+		sort.SliceStable(value, func(i, j int) bool {
+			a, _ := json.Marshal(value[i])
+			b, _ := json.Marshal(value[j])
+			return string(a) < string(b)
+		})
+	}
+}
+
+func validateAccessPoint(addr string, port int) error {
+	if addr == "" {
+		return fmt.Errorf("empty address")
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d for '%s'", port, addr)
+	}
+	return nil
+}
+
+// ValidateTopology checks that data is a legacy or P2P topology file
+// with valid addresses, ports and valencies
+func ValidateTopology(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	if _, ok := keys["Producers"]; ok {
+		var topology PullPayload
+		if err := json.Unmarshal(data, &topology); err != nil {
+			return err
+		}
+		for _, p := range topology.Producers {
+			if err := validateAccessPoint(p.Addr, p.Port); err != nil {
+				return err
+			}
+			if p.Valency < 1 {
+				return fmt.Errorf("invalid valency %d for '%s'", p.Valency, p.Addr)
+			}
+		}
+		return nil
+	}
+	_, hasLocalRoots := keys["localRoots"]
+	_, hasPublicRoots := keys["publicRoots"]
+	if !hasLocalRoots && !hasPublicRoots {
+		return fmt.Errorf("neither Producers nor localRoots and publicRoots found")
+	}
+	var topology P2PTopology
+	if err := json.Unmarshal(data, &topology); err != nil {
+		return err
+	}
+	for _, ap := range topology.BootstrapPeers {
+		if err := validateAccessPoint(ap.Address, ap.Port); err != nil {
+			return err
+		}
+	}
+	for _, group := range topology.LocalRoots {
+		// a DNS name can resolve to more peers than its access point
+		resolvable := false
+		for _, ap := range group.AccessPoints {
+			if err := validateAccessPoint(ap.Address, ap.Port); err != nil {
+				return err
+			}
+			resolvable = resolvable || net.ParseIP(ap.Address) == nil
+		}
+		if !resolvable && group.HotValency > len(group.AccessPoints) {
+			return fmt.Errorf("hot valency %d exceeds the %d local root access points", group.HotValency, len(group.AccessPoints))
+		}
+	}
+	for _, group := range topology.PublicRoots {
+		for _, ap := range group.AccessPoints {
+			if err := validateAccessPoint(ap.Address, ap.Port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sampleP2PTopology = `{
+  "bootstrapPeers": [
+    {
+      "address": "backbone.cardano.iog.io",
+      "port": 3001
+    }
+  ],
+  "localRoots": [
+    {
+      "accessPoints": [
+        {
+          "address": "10.0.0.1",
+          "port": 3001
+        }
+      ],
+      "advertise": false,
+      "trustable": true,
+      "valency": 1,
+      "hotValency": 1
+    }
+  ],
+  "publicRoots": [
+    {
+      "accessPoints": [
+        {
+          "address": "23.94.134.119",
+          "port": 5001
+        },
+        {
+          "address": "relays.example.com",
+          "port": 3001
+        }
+      ],
+      "advertise": false
+    }
+  ],
+  "useLedgerAfterSlot": 128908821
+}`
+
+func TestP2PTopology(t *testing.T) {
+	producers := []Producer{
+		{Addr: "23.94.134.119", Port: 5001, Valency: 1},
+		{Addr: "relays.example.com", Port: 3001, Valency: 2},
+	}
+	custom := []Producer{{Addr: "10.0.0.1", Port: 3001, Valency: 1}}
+	bootstrap := []Producer{{Addr: "backbone.cardano.iog.io", Port: 3001, Valency: 1}}
+	out, err := json.MarshalIndent(NewP2PTopology(producers, custom, bootstrap, 128908821), "", "  ")
+	require.NoError(t, err)
+	require.Equal(t, sampleP2PTopology, string(out))
+}
+
+func TestP2PTopologyCustomValency(t *testing.T) {
+	custom := []Producer{
+		{Addr: "10.0.0.1", Port: 3001, Valency: 1},
+		{Addr: "relays.mydomain.com", Port: 3003, Valency: 3},
+		{Addr: "10.0.0.2", Port: 3002},
+		{Addr: "10.0.0.3", Port: 3002, Valency: 2},
+	}
+	topology := NewP2PTopology(nil, custom, nil, -1)
+	require.Len(t, topology.LocalRoots, 1)
+	require.Equal(t, 6, topology.LocalRoots[0].Valency)
+	require.Equal(t, 6, topology.LocalRoots[0].HotValency)
+	out, err := json.Marshal(topology)
+	require.NoError(t, err)
+	require.NoError(t, ValidateTopology(out))
+}
+
+func TestP2PTopologyDefaults(t *testing.T) {
+	out, err := json.Marshal(NewP2PTopology(nil, nil, nil, -1))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"bootstrapPeers": null, "localRoots": [], "publicRoots": [], "useLedgerAfterSlot": -1}`, string(out))
+}
+
+func TestParseTopologyProducers(t *testing.T) {
+	producers, err := ParseTopologyProducers([]byte(sampleP2PTopology))
+	require.NoError(t, err)
+	require.Equal(t, []Producer{
+		{Addr: "23.94.134.119", Port: 5001, Valency: 1},
+		{Addr: "relays.example.com", Port: 3001, Valency: 1},
+	}, producers)
+
+	producers, err = ParseTopologyProducers([]byte(`{"Producers": [{"addr": "10.0.0.1", "port": 3001, "valency": 2}]}`))
+	require.NoError(t, err)
+	require.Equal(t, []Producer{{Addr: "10.0.0.1", Port: 3001, Valency: 2}}, producers)
+
+	_, err = ParseTopologyProducers([]byte(`{`))
+	require.Error(t, err)
+}
+
+func TestValidateTopology(t *testing.T) {
+	require.NoError(t, ValidateTopology([]byte(sampleP2PTopology)))
+	require.NoError(t, ValidateTopology([]byte(`{"Producers": [{"addr": "10.0.0.1", "port": 3001, "valency": 1}]}`)))
+	require.NoError(t, ValidateTopology([]byte(`{"localRoots": [], "publicRoots": []}`)))
+
+	require.Error(t, ValidateTopology([]byte(`{"Producers": [{"addr": "10.0.0.1", "port": 3001, "valency": 1}]`)))
+	require.Error(t, ValidateTopology([]byte(`{"peers": []}`)))
+	require.Error(t, ValidateTopology([]byte(`{"Producers": [{"addr": "", "port": 3001, "valency": 1}]}`)))
+	require.Error(t, ValidateTopology([]byte(`{"Producers": [{"addr": "10.0.0.1", "port": 0, "valency": 1}]}`)))
+	require.Error(t, ValidateTopology([]byte(`{"Producers": [{"addr": "10.0.0.1", "port": 3001, "valency": 0}]}`)))
+	require.Error(t, ValidateTopology([]byte(`{"publicRoots": [{"accessPoints": [{"address": "10.0.0.1", "port": 70000}]}]}`)))
+	require.Error(t, ValidateTopology([]byte(`{"localRoots": [{"accessPoints": [], "hotValency": 1}]}`)))
+	require.Error(t, ValidateTopology([]byte(`{"localRoots": [{"accessPoints": [{"address": "10.0.0.1", "port": 3001}], "hotValency": 2}]}`)))
+	require.NoError(t, ValidateTopology([]byte(`{"localRoots": [{"accessPoints": [{"address": "relays.example.com", "port": 3001}], "hotValency": 2}]}`)))
+}
+
+func TestTopologyChanged(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "topology.json")
+	legacy := `{"resultcode":"201","datetime":"2022-02-05 16:54:21","clientIp":"10.0.0.1","msg":"hi",` +
+		`"Producers":[{"addr":"10.0.0.1","port":3001,"valency":1},{"addr":"10.0.0.2","port":3001,"valency":1}]}`
+	require.True(t, TopologyChanged(filename, []byte(legacy)))
+	require.NoError(t, WriteFileAtomic(filename, []byte(legacy), 0))
+	require.False(t, TopologyChanged(filename, []byte(legacy)))
+
+	// another fetch of the same producers, in another order
+	refetched := `{"resultcode":"200","datetime":"2022-02-05 17:54:21","clientIp":"10.0.0.3","msg":"hello",` +
+		`"Producers":[{"addr":"10.0.0.2","port":3001,"valency":1},{"addr":"10.0.0.1","port":3001,"valency":1}]}`
+	require.False(t, TopologyChanged(filename, []byte(refetched)))
+	require.True(t, TopologyChanged(filename, []byte(`{"Producers":[{"addr":"10.0.0.1","port":3001,"valency":1}]}`)))
+
+	require.NoError(t, WriteFileAtomic(filename, []byte(sampleP2PTopology), 0))
+	require.False(t, TopologyChanged(filename, []byte(sampleP2PTopology)))
+	require.True(t, TopologyChanged(filename, []byte(legacy)))
+
+	require.NoError(t, WriteFileAtomic(filename, []byte("not json"), 0))
+	require.False(t, TopologyChanged(filename, []byte("not json")))
+	require.True(t, TopologyChanged(filename, []byte("{}")))
+}
@@ -7,6 +7,7 @@ import (
 const (
 	requestMaxWaitTime = 5 * time.Second
 	maxResponseLen     = 16 * 1024
+	maxMetricsLen      = 1024 * 1024
 )
 
 const (
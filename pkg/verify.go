@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PoolSource lists the relays registered in the certificates of active pools
+type PoolSource interface {
+	Relays(ctx context.Context) ([]PoolRelay, error)
+}
+
+type ogmiosPoolSource struct {
+	url string
+}
+
+// NewOgmiosPoolSource returns a PoolSource reading pool parameters from an Ogmios websocket
+func NewOgmiosPoolSource(url string) PoolSource {
+	return &ogmiosPoolSource{url: url}
+}
+
+func (s *ogmiosPoolSource) Relays(ctx context.Context) ([]PoolRelay, error) {
+	poolIds, err := getPoolIds(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool ids: %v", err)
+	}
+	if len(poolIds) == 0 {
+		return nil, fmt.Errorf("no registered pool")
+	}
+	pools, err := getPoolsParameters(s.url, poolIds...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool parameters: %v", err)
+	}
+	relays := make([]PoolRelay, 0)
+	for _, pool := range pools {
+		relays = append(relays, pool.Relays...)
+	}
+	return relays, nil
+}
+
+func relayKey(addr string, port int) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		addr = ip.String()
+	}
+	return net.JoinHostPort(strings.ToLower(addr), strconv.Itoa(port))
+}
+
+// resolveRelays returns the ip:port keys of relays registered with a hostname
+// on one of the given ports
+func resolveRelays(ctx context.Context, relays []PoolRelay, ports map[int]bool) map[string]bool {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	keys := make(map[string]bool)
+	ch := make(chan PoolRelay)
+	wg.Add(MaxWorkers)
+	for i := 0; i < MaxWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for relay := range ch {
+				lookupCtx, cancel := context.WithTimeout(ctx, RequestMaxWaitTime)
+				addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, *relay.HostName)
+				cancel()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				for _, addr := range addrs {
+					keys[relayKey(addr.IP.String(), relay.Port)] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, relay := range relays {
+		if relay.Ip4 == nil && relay.Ip6 == nil && relay.HostName != nil && ports[relay.Port] {
+			ch <- relay
+		}
+	}
+	close(ch)
+	wg.Wait()
+	return keys
+}
+
+// VerifyProducers keeps the producers registered as a relay of an active pool.
+// A producer matches if its address and port are registered, or if it is the IP address
+// of a relay registered with a hostname. Dropped producers are returned separately.
+func VerifyProducers(ctx context.Context, source PoolSource, producers []Producer) ([]Producer, []Producer, error) {
+	relays, err := source.Relays(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	registered := make(map[string]bool)
+	for _, relay := range relays {
+		if addr, _ := relayAddr(relay); addr != "" {
+			registered[relayKey(addr, relay.Port)] = true
+		}
+	}
+
+	ports := make(map[int]bool)
+	for _, p := range producers {
+		if !registered[relayKey(p.Addr, p.Port)] && net.ParseIP(p.Addr) != nil {
+			ports[p.Port] = true
+		}
+	}
+	if len(ports) > 0 {
+		for key := range resolveRelays(ctx, relays, ports) {
+			registered[key] = true
+		}
+	}
+	kept := make([]Producer, 0, len(producers))
+	dropped := make([]Producer, 0)
+	for _, p := range producers {
+		if registered[relayKey(p.Addr, p.Port)] {
+			kept = append(kept, p)
+		} else {
+			dropped = append(dropped, p)
+		}
+	}
+	return kept, dropped, nil
+}
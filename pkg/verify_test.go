@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticPoolSource []PoolRelay
+
+func (s staticPoolSource) Relays(ctx context.Context) ([]PoolRelay, error) {
+	return s, nil
+}
+
+func TestVerifyProducers(t *testing.T) {
+	ip4 := "10.0.0.1"
+	ip6 := "2001:db8:0:0::1"
+	host := "localhost"
+	source := staticPoolSource{
+		{Port: 3001, Ip4: &ip4},
+		{Port: 3001, Ip6: &ip6},
+		{Port: 6000, HostName: &host},
+	}
+	producers := []Producer{
+		{Addr: "10.0.0.1", Port: 3001, Valency: 1},
+		{Addr: "10.0.0.1", Port: 3002, Valency: 1},
+		{Addr: "2001:db8::1", Port: 3001, Valency: 1},
+		{Addr: "LOCALHOST", Port: 6000, Valency: 1},
+		{Addr: "127.0.0.1", Port: 6000, Valency: 1},
+		{Addr: "10.6.6.6", Port: 3001, Valency: 1},
+		{Addr: "evil.example.com", Port: 3001, Valency: 1},
+	}
+	kept, dropped, err := VerifyProducers(context.Background(), source, producers)
+	require.NoError(t, err)
+	require.Equal(t, []Producer{producers[0], producers[2], producers[3], producers[4]}, kept)
+	require.Equal(t, []Producer{producers[1], producers[5], producers[6]}, dropped)
+}
+
+func TestOgmiosPoolSource(t *testing.T) {
+	ip4 := "10.0.0.1"
+	ogmios := newOgmiosServer(t, map[string]PoolParameters{
+		"pool1a": {Id: "pool1a", Relays: []PoolRelay{{Port: 3001, Ip4: &ip4}}},
+		"pool1b": {Id: "pool1b"},
+	})
+	defer ogmios.Close()
+
+	relays, err := NewOgmiosPoolSource("ws"+strings.TrimPrefix(ogmios.URL, "http")).Relays(context.Background())
+	require.NoError(t, err)
+	require.Len(t, relays, 1)
+	require.Equal(t, ip4, *relays[0].Ip4)
+}
@@ -7,8 +7,12 @@ import (
 	"github.com/dchest/blake2b"
 	"github.com/gorilla/websocket"
 	"github.com/regel/cardano-p2p/log"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
 	"sync"
@@ -37,6 +41,15 @@ type BlockHeightResponse struct {
 	Result *int64 `json:"result"`
 }
 
+type ChainTip struct {
+	Slot uint64 `json:"slot"`
+	Hash string `json:"hash"`
+}
+
+type ChainTipResponse struct {
+	Result *ChainTip `json:"result"`
+}
+
 type PoolRelay struct {
 	Port     int     `json:"port"`
 	Ip4      *string `json:"ipv4"`
@@ -49,7 +62,14 @@ type PoolMetadata struct {
 	Hash string `json:"hash"`
 }
 
+type PoolMetadataContent struct {
+	Name   string `json:"name"`
+	Ticker string `json:"ticker"`
+}
+
 type PoolParameters struct {
+	Ticker        string       `json:"-"`
+	Stake         float64      `json:"-"`
 	Id            string       `json:"id"`
 	Vrf           string       `json:"vrf"`
 	Pledge        uint64       `json:"pledge"`
@@ -65,6 +85,15 @@ type PoolParametersResponse struct {
 	Result map[string]PoolParameters `json:"result"`
 }
 
+type PoolStake struct {
+	Stake string `json:"stake"`
+	Vrf   string `json:"vrf"`
+}
+
+type StakeDistributionResponse struct {
+	Result map[string]PoolStake `json:"result"`
+}
+
 func buildPoolIdsQuery() Query {
 	args := QueryArgs{
 		Query: "poolIds",
@@ -93,12 +122,23 @@ func buildblockHeightQuery() Query {
 	return query
 }
 
-func buildPoolParametersQuery(poolId string) Query {
-	var poolIds = map[string][]string{
-		"poolParameters": []string{poolId},
+func buildChainTipQuery() Query {
+	args := QueryArgs{
+		Query: "chainTip",
+	}
+	query := Query{
+		MethodName:  WebsocketMethodName,
+		ServiceName: WebsocketServiceName,
+		QueryType:   WebsocketQueryType,
+		Version:     WebsocketVersion,
+		QueryArgs:   args,
 	}
+	return query
+}
+
+func buildStakeDistributionQuery() Query {
 	args := QueryArgs{
-		Query: poolIds,
+		Query: "liveStakeDistribution",
 	}
 	query := Query{
 		MethodName:  WebsocketMethodName,
@@ -110,16 +150,67 @@ func buildPoolParametersQuery(poolId string) Query {
 	return query
 }
 
+func buildPoolParametersQuery(poolIds ...string) Query {
+	var query = map[string][]string{
+		"poolParameters": poolIds,
+	}
+	args := QueryArgs{
+		Query: query,
+	}
+	return Query{
+		MethodName:  WebsocketMethodName,
+		ServiceName: WebsocketServiceName,
+		QueryType:   WebsocketQueryType,
+		Version:     WebsocketVersion,
+		QueryArgs:   args,
+	}
+}
+
 func GetBlockHeight(url string) (*int64, error) {
-	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	return GetBlockHeightContext(context.Background(), url)
+}
+
+// GetBlockHeightContext is GetBlockHeight with the deadline of ctx applied
+// to the connection, the query and the answer
+func GetBlockHeightContext(ctx context.Context, url string) (*int64, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to %q: %v\n", url, err)
 	}
 	defer ws.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = ws.SetWriteDeadline(deadline)
+		_ = ws.SetReadDeadline(deadline)
+	}
 
 	msg := buildblockHeightQuery()
 	data, _ := json.Marshal(msg)
 	err = ws.WriteMessage(websocket.TextMessage, data)
+	if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return nil, fmt.Errorf("unexpected write error %v\n", err)
+	}
+	_, message, err := ws.ReadMessage()
+	if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return nil, fmt.Errorf("unexpected read error %v\n", err)
+	}
+	var blockHeight BlockHeightResponse
+	err = json.Unmarshal(message, &blockHeight)
+	if err != nil {
+		return nil, err
+	}
+	return blockHeight.Result, nil
+}
+
+func GetChainTip(url string) (*ChainTip, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %q: %v\n", url, err)
+	}
+	defer ws.Close()
+
+	msg := buildChainTipQuery()
+	data, _ := json.Marshal(msg)
+	err = ws.WriteMessage(websocket.TextMessage, data)
 	if err != nil {
 		if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
 			return nil, fmt.Errorf("unexpected write error %v\n", err)
@@ -131,16 +222,34 @@ func GetBlockHeight(url string) (*int64, error) {
 			return nil, fmt.Errorf("unexpected read error %v\n", err)
 		}
 	}
-	var blockHeight BlockHeightResponse
-	err = json.Unmarshal(message, &blockHeight)
+	var origin struct {
+		Result string `json:"result"`
+	}
+	if json.Unmarshal(message, &origin) == nil && origin.Result == "origin" {
+		return &ChainTip{}, nil
+	}
+	var chainTip ChainTipResponse
+	err = json.Unmarshal(message, &chainTip)
 	if err != nil {
 		return nil, err
 	}
-	return blockHeight.Result, nil
+	if chainTip.Result == nil {
+		return nil, fmt.Errorf("no chain tip in response")
+	}
+	return chainTip.Result, nil
 }
 
 func getPoolParameters(url string, poolId string) (*PoolParameters, error) {
-	msg := buildPoolParametersQuery(poolId)
+	pools, err := getPoolsParameters(url, poolId)
+	if err != nil {
+		return nil, err
+	}
+	poolParameters := pools[poolId]
+	return &poolParameters, nil
+}
+
+func getPoolsParameters(url string, poolIds ...string) (map[string]PoolParameters, error) {
+	msg := buildPoolParametersQuery(poolIds...)
 	data, _ := json.Marshal(msg)
 	// ensures that no more than one goroutine calls the write methods on the same ws
 	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
@@ -165,50 +274,82 @@ func getPoolParameters(url string, poolId string) (*PoolParameters, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Unmarshal error: %v\n", err)
 	}
-	poolParameters := response.Result[poolId]
-	return &poolParameters, nil
+	return response.Result, nil
 }
 
-func vetPool(url string, client *http.Client, poolId string) (*PoolParameters, error) {
+// vetPool gets the pool parameters and verifies the pool metadata hash.
+// Pool parameters are returned along with the error when they are known.
+func vetPool(ctx context.Context, url string, client *http.Client, poolId string) (poolParameters *PoolParameters, err error) {
+	ctx, span := tracer().Start(ctx, "vetPool", trace.WithAttributes(attribute.String("pool.id", poolId)))
+	defer func() {
+		if e, ok := err.(*VetError); ok {
+			span.SetAttributes(attribute.String("pool.verdict", string(e.Verdict)))
+		}
+		endSpan(span, err)
+	}()
+
+	_, querySpan := tracer().Start(ctx, "ogmios.poolParameters")
 	// ensures that no more than one goroutine calls the write methods on the same ws
 	mutex.Lock()
-	poolParameters, err := getPoolParameters(url, poolId)
+	poolParameters, err = getPoolParameters(url, poolId)
 	mutex.Unlock()
+	endSpan(querySpan, err)
 	if err != nil {
-		return nil, err
+		return nil, &VetError{VerdictQueryFailed, err}
 	}
 	if len(poolParameters.Relays) == 0 {
-		return nil, fmt.Errorf("No relays")
+		return poolParameters, &VetError{VerdictNoRelays, fmt.Errorf("No relays")}
+	}
+	buf, err := getMetadata(ctx, client, poolParameters.Metadata.Url)
+	if err != nil {
+		return poolParameters, &VetError{VerdictMetadataFetchFailed, err}
+	}
+	sum := blake2b.Sum256(buf)
+	if fmt.Sprintf("%x", sum) != poolParameters.Metadata.Hash {
+		return poolParameters, &VetError{VerdictHashMismatch, fmt.Errorf("invalid hash expected '%s' was '%s'", poolParameters.Metadata.Hash, fmt.Sprintf("%x", sum))}
+	}
+	log.Infof("Verified hash for pool '%s' at url '%s'", poolId, poolParameters.Metadata.Url)
+	var content PoolMetadataContent
+	if err := json.Unmarshal(buf, &content); err == nil {
+		poolParameters.Ticker = content.Ticker
 	}
+	return poolParameters, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), RequestMaxWaitTime)
+// getMetadata downloads the pool metadata found at url
+func getMetadata(ctx context.Context, client *http.Client, url string) (buf []byte, err error) {
+	ctx, span := tracer().Start(ctx, "metadata.get", trace.WithAttributes(semconv.HTTPURLKey.String(url)))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, RequestMaxWaitTime)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, poolParameters.Metadata.Url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot create request: %v", err)
 	}
 	req.Close = true
+	start := time.Now()
 	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
 	if e, ok := err.(net.Error); ok && e.Timeout() {
-		return nil, fmt.Errorf("Get '%s' timeout", poolParameters.Metadata.Url)
+		metadataFetchDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("Get '%s' timeout", url)
 	} else if err != nil {
+		metadataFetchDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
 		return nil, fmt.Errorf("Cannot do request: %v", err)
 	}
-	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxMetadataLen))
-	if err != nil {
-		return nil, fmt.Errorf("Cannot get all response body at url '%s': %v", poolParameters.Metadata.Url, err)
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		metadataFetchDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("Get '%s': %s", url, resp.Status)
 	}
-	sum := blake2b.Sum256(buf)
-	if fmt.Sprintf("%x", sum) != poolParameters.Metadata.Hash {
-		return nil, fmt.Errorf("invalid hash expected '%s' was '%s'", poolParameters.Metadata.Hash, fmt.Sprintf("%x", sum))
+	buf, err = ioutil.ReadAll(io.LimitReader(resp.Body, MaxMetadataLen))
+	if err != nil {
+		metadataFetchDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("Cannot get all response body at url '%s': %v", url, err)
 	}
-	log.Infof("Verified hash for pool '%s' at url '%s'", poolId, poolParameters.Metadata.Url)
-	return poolParameters, nil
+	metadataFetchDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	return buf, nil
 }
 
 func getPoolIds(url string) ([]string, error) {
@@ -240,16 +381,75 @@ func getPoolIds(url string) ([]string, error) {
 	return poolIds.Result, nil
 }
 
-func VetPools(url string) ([]*PoolParameters, error) {
+// parseStake converts a ratio such as "123/456" to a float
+func parseStake(s string) float64 {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0
+	}
+	f, _ := r.Float64()
+	return f
+}
+
+func getStakeDistribution(url string) (map[string]float64, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %q: %v\n", url, err)
+	}
+	defer ws.Close()
+
+	msg := buildStakeDistributionQuery()
+	data, _ := json.Marshal(msg)
+	err = ws.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
+			return nil, fmt.Errorf("unexpected write error %v\n", err)
+		}
+	}
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
+			return nil, fmt.Errorf("unexpected read error %v\n", err)
+		}
+	}
+	var response StakeDistributionResponse
+	err = json.Unmarshal(message, &response)
+	if err != nil {
+		return nil, err
+	}
+	stakes := make(map[string]float64, len(response.Result))
+	for poolId, stake := range response.Result {
+		stakes[poolId] = parseStake(stake.Stake)
+	}
+	return stakes, nil
+}
+
+// VetPools gets the parameters of every registered pool and verifies their metadata.
+// A report is returned for each pool, with VerdictVetted if the pool passed the checks.
+func VetPools(ctx context.Context, url string) (reports []*PoolReport, err error) {
 	var wg sync.WaitGroup
 	var ch = make(chan string, MaxWorkers)
 
+	ctx, span := tracer().Start(ctx, "VetPools")
+	defer func() {
+		span.SetAttributes(attribute.Int("pools", len(reports)))
+		endSpan(span, err)
+	}()
+
+	_, querySpan := tracer().Start(ctx, "ogmios.poolIds")
 	poolIds, err := getPoolIds(url)
+	endSpan(querySpan, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pool ids: %v\n", err)
 	}
+	_, querySpan = tracer().Start(ctx, "ogmios.liveStakeDistribution")
+	stakes, err := getStakeDistribution(url)
+	endSpan(querySpan, err)
+	if err != nil {
+		log.Errorf("Could not get stake distribution: %v", err)
+	}
 
-	poolChan := make(chan *PoolParameters)
+	reportChan := make(chan *PoolReport)
 	wg.Add(MaxWorkers)
 	for i := 0; i < MaxWorkers; i++ {
 		go func() {
@@ -268,26 +468,33 @@ func VetPools(url string) ([]*PoolParameters, error) {
 					wg.Done()
 					return
 				}
-				parameters, err := vetPool(url, cli, pool)
+				parameters, err := vetPool(ctx, url, cli, pool)
+				report := newPoolReport(pool, parameters, err)
+				report.Stake = stakes[pool]
+				if parameters != nil {
+					parameters.Stake = report.Stake
+				}
 				if err != nil {
 					log.Errorf("Error fetching pool '%s' data: %v", pool, err)
-					continue
 				}
-				poolChan <- parameters
+				reportChan <- report
 			}
 		}()
 	}
-	pools := make([]*PoolParameters, 0)
+	reports = make([]*PoolReport, 0, len(poolIds))
+	done := make(chan struct{})
 	go func() {
-		for parameters := range poolChan {
-			pools = append(pools, parameters)
+		for report := range reportChan {
+			reports = append(reports, report)
 		}
+		close(done)
 	}()
 	for _, poolId := range poolIds {
 		ch <- poolId
 	}
 	close(ch)
 	wg.Wait()
-	close(poolChan)
-	return pools, nil
+	close(reportChan)
+	<-done
+	return reports, nil
 }
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dchest/blake2b"
+	"github.com/gorilla/websocket"
+	"github.com/regel/cardano-p2p/server"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const sampleMetadata = `{"name": "Sample", "ticker": "SMPL", "homepage": "https://example.com"}`
+
+// newOgmiosServer mocks the Ogmios local state queries used to vet pools
+func newOgmiosServer(t *testing.T, pools map[string]PoolParameters) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer ws.Close()
+		var query Query
+		require.NoError(t, ws.ReadJSON(&query))
+		switch q := query.QueryArgs.Query.(type) {
+		case string:
+			switch q {
+			case "poolIds":
+				ids := make([]string, 0)
+				for id := range pools {
+					ids = append(ids, id)
+				}
+				sort.Strings(ids)
+				_ = ws.WriteJSON(PoolIdsResponse{Result: ids})
+			case "liveStakeDistribution":
+				stakes := make(map[string]PoolStake)
+				for id := range pools {
+					stakes[id] = PoolStake{Stake: "1/4"}
+				}
+				_ = ws.WriteJSON(StakeDistributionResponse{Result: stakes})
+			case "blockHeight":
+				_ = ws.WriteMessage(websocket.TextMessage, []byte(`{"result": 1234}`))
+			}
+		case map[string]interface{}:
+			result := make(map[string]PoolParameters)
+			for _, id := range q["poolParameters"].([]interface{}) {
+				result[id.(string)] = pools[id.(string)]
+			}
+			_ = ws.WriteJSON(PoolParametersResponse{Result: result})
+		}
+	}))
+}
+
+func TestVetPools(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.json" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, sampleMetadata)
+	}))
+	defer metadata.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	addr := "10.0.0.1"
+	relays := []PoolRelay{{Port: 3001, Ip4: &addr}}
+	hash := fmt.Sprintf("%x", blake2b.Sum256([]byte(sampleMetadata)))
+	ogmios := newOgmiosServer(t, map[string]PoolParameters{
+		"pool1a": {Id: "pool1a", Relays: relays, Metadata: PoolMetadata{Url: metadata.URL + "/ok.json", Hash: hash}},
+		"pool1b": {Id: "pool1b", Relays: relays, Metadata: PoolMetadata{Url: metadata.URL + "/ok.json", Hash: "00"}},
+		"pool1c": {Id: "pool1c", Metadata: PoolMetadata{Url: metadata.URL + "/ok.json", Hash: hash}},
+		"pool1d": {Id: "pool1d", Relays: relays, Metadata: PoolMetadata{Url: closed.URL + "/ok.json", Hash: hash}},
+		"pool1e": {Id: "pool1e", Relays: relays, Metadata: PoolMetadata{Url: metadata.URL + "/missing.json", Hash: hash}},
+	})
+	defer ogmios.Close()
+
+	reports, err := VetPools(context.Background(), "ws"+strings.TrimPrefix(ogmios.URL, "http"))
+	require.NoError(t, err)
+	require.Len(t, reports, 5)
+	verdicts := make(map[string]*PoolReport)
+	for _, report := range reports {
+		verdicts[report.PoolId] = report
+	}
+	require.Equal(t, VerdictVetted, verdicts["pool1a"].Verdict)
+	require.Equal(t, "SMPL", verdicts["pool1a"].Ticker)
+	require.Equal(t, 0.25, verdicts["pool1a"].Stake)
+	require.Equal(t, VerdictHashMismatch, verdicts["pool1b"].Verdict)
+	require.Equal(t, VerdictNoRelays, verdicts["pool1c"].Verdict)
+	require.Equal(t, VerdictMetadataFetchFailed, verdicts["pool1d"].Verdict)
+	require.NotEmpty(t, verdicts["pool1d"].Error)
+	require.Equal(t, VerdictMetadataFetchFailed, verdicts["pool1e"].Verdict)
+	require.Contains(t, verdicts["pool1e"].Error, "404")
+}
+
+func TestVetPoolsSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewTracerProvider(sdktrace.NewSimpleSpanProcessor(exporter), &server.DefaultConfig().Tracing)
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+
+	ogmios := newOgmiosServer(t, map[string]PoolParameters{
+		"pool1c": {Id: "pool1c"},
+	})
+	defer ogmios.Close()
+
+	_, err := VetPools(context.Background(), "ws"+strings.TrimPrefix(ogmios.URL, "http"))
+	require.NoError(t, err)
+	names := make(map[string]int)
+	for _, span := range exporter.GetSpans() {
+		names[span.Name]++
+	}
+	require.Equal(t, 1, names["VetPools"])
+	require.Equal(t, 1, names["ogmios.poolIds"])
+	require.Equal(t, 1, names["vetPool"])
+	require.Equal(t, 1, names["ogmios.poolParameters"])
+}
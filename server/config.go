@@ -16,22 +16,33 @@ limitations under the License.
 package server
 
 import (
+	"fmt"
+	"reflect"
 	"github.com/pkg/errors"
 	"github.com/regel/cardano-p2p/log"
 	"github.com/spf13/viper"
+	"strconv"
 	"time"
 )
 
 var (
 	defaultListenAddr     = ":8080"
 	defaultClientEndpoint = "ws://localhost:1337"
-	testnetMagic          = uint64(1097911063)
 	defaultMaximumPeers   = 10
 	defaultPeriodSeconds  = 60 * time.Second
 	defaultFetchMaximum   = 2000
 	defaultReadTimeout    = 1 * time.Second
 	defaultProbeTimeout   = 1 * time.Second
-	defaultPeerAddr       = "relays-new.cardano-testnet.iohkdev.io:3001"
+	defaultSnapshotStake  = 90.0
+	defaultTracingAddr    = "localhost:4318"
+	defaultServiceName    = "cardano-p2p"
+	defaultGossipInterval = 5 * time.Minute
+	defaultGossipMaxAge   = 2 * time.Hour
+)
+
+const (
+	ProbeModeTCP       = "tcp"
+	ProbeModeHandshake = "handshake"
 )
 
 type ClientConfig struct {
@@ -40,20 +51,173 @@ type ClientConfig struct {
 	PeriodSeconds time.Duration `mapstructure:"period-seconds,omitempty"`
 	FetchMaximum  int           `mapstructure:"fetch-maximum,omitempty"`
 	ProbeTimeout  time.Duration `mapstructure:"probe-timeout,omitempty"`
+	ProbeMode     string        `mapstructure:"probe-mode,omitempty"`
+}
+
+type TLSConfig struct {
+	CertFile          string `mapstructure:"cert-file,omitempty"`
+	KeyFile           string `mapstructure:"key-file,omitempty"`
+	ClientCAFile      string `mapstructure:"client-ca-file,omitempty"`
+	RequireClientCert bool   `mapstructure:"require-client-cert,omitempty"`
+}
+
+// Enabled returns true if the listener must serve https
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// GossipConfig configures the exchange of probe observations between p2p servers
+type GossipConfig struct {
+	Enabled        bool          `mapstructure:"enabled,omitempty"`
+	ServerId       string        `mapstructure:"server-id,omitempty"`
+	Peers          []string      `mapstructure:"peers,omitempty"`
+	Token          string        `mapstructure:"token,omitempty"`
+	Interval       time.Duration `mapstructure:"interval,omitempty"`
+	MaxAge         time.Duration `mapstructure:"max-age,omitempty"`
+	Quorum         int           `mapstructure:"quorum,omitempty"`
+	ClientCertFile string        `mapstructure:"client-cert-file,omitempty"`
+	ClientKeyFile  string        `mapstructure:"client-key-file,omitempty"`
+	CAFile         string        `mapstructure:"ca-file,omitempty"`
 }
 
 type ServerConfig struct {
-	MaxPeers      int           `mapstructure:"max-peers,omitempty"`
-	NetworkMagic  uint64        `mapstructure:"magic,omitempty"`
-	DefaultPeer   string        `mapstructure:"default-peer,omitempty"`
-	ListenAddress string        `mapstructure:"listen-addr,omitempty"`
-	ReadTimeout   time.Duration `mapstructure:"read-timeout,omitempty"`
+	MaxPeers             int           `mapstructure:"max-peers,omitempty"`
+	Network              string        `mapstructure:"network,omitempty"`
+	NetworkMagic         uint64        `mapstructure:"magic,omitempty"`
+	DefaultPeer          string        `mapstructure:"default-peer,omitempty"`
+	ListenAddress        string        `mapstructure:"listen-addr,omitempty"`
+	ReadTimeout          time.Duration `mapstructure:"read-timeout,omitempty"`
+	SnapshotStakePercent float64       `mapstructure:"snapshot-stake-percent,omitempty"`
+	SigningKey           string        `mapstructure:"signing-key,omitempty"`
+	TipToken             string        `mapstructure:"tip-token,omitempty"`
+	TLS                  TLSConfig     `mapstructure:"tls,omitempty"`
+	Gossip               GossipConfig  `mapstructure:"gossip,omitempty"`
+}
+
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled,omitempty"`
+	Endpoint    string  `mapstructure:"endpoint,omitempty"`
+	Insecure    bool    `mapstructure:"insecure,omitempty"`
+	SampleRatio float64 `mapstructure:"sample-ratio,omitempty"`
+	ServiceName string  `mapstructure:"service-name,omitempty"`
+}
+
+// NetworkClientConfig overrides the client section for one network.
+// Enabled is a pointer so that a network can disable a client enabled by the client section.
+type NetworkClientConfig struct {
+	Enabled       *bool         `mapstructure:"enabled,omitempty"`
+	Endpoint      string        `mapstructure:"endpoint,omitempty"`
+	PeriodSeconds time.Duration `mapstructure:"period-seconds,omitempty"`
+	FetchMaximum  int           `mapstructure:"fetch-maximum,omitempty"`
+	ProbeTimeout  time.Duration `mapstructure:"probe-timeout,omitempty"`
+	ProbeMode     string        `mapstructure:"probe-mode,omitempty"`
+}
+
+// NetworkConfig overrides the server and client settings for one network
+// served by the same process. Unset fields inherit the server and client sections,
+// except the default peer which is only inherited by the network of the server section.
+type NetworkConfig struct {
+	Name                 string              `mapstructure:"name,omitempty"`
+	Network              string              `mapstructure:"network,omitempty"`
+	NetworkMagic         uint64              `mapstructure:"magic,omitempty"`
+	DefaultPeer          string              `mapstructure:"default-peer,omitempty"`
+	SnapshotStakePercent float64             `mapstructure:"snapshot-stake-percent,omitempty"`
+	Client               NetworkClientConfig `mapstructure:"client,omitempty"`
 }
 
 type Config struct {
-	Debug  bool         `mapstructure:"debug,omitempty"`
-	Server ServerConfig `mapstructure:"server,omitempty"`
-	Client ClientConfig `mapstructure:"client,omitempty"`
+	Debug          bool            `mapstructure:"debug,omitempty"`
+	Server         ServerConfig    `mapstructure:"server,omitempty"`
+	Client         ClientConfig    `mapstructure:"client,omitempty"`
+	Networks       []NetworkConfig `mapstructure:"networks,omitempty"`
+	NetworkPresets []NetworkPreset `mapstructure:"network-presets,omitempty"`
+	Tracing        TracingConfig   `mapstructure:"tracing,omitempty"`
+}
+
+// preset returns the custom or built-in preset of the network magic, if any
+func (c *Config) preset(magic uint64) (NetworkPreset, bool) {
+	p, err := LookupNetwork(strconv.FormatUint(magic, 10), c.NetworkPresets)
+	return p, err == nil && p.Name != NetworkCustom
+}
+
+// defaultPeer returns the first bootstrap peer of the network magic preset
+func (c *Config) defaultPeer(magic uint64) string {
+	if p, ok := c.preset(magic); ok && len(p.BootstrapPeers) > 0 {
+		return p.BootstrapPeers[0]
+	}
+	return ""
+}
+
+// ResolveNetworks sets the magic of the server and of each network from their
+// network preset name, and their default peer from the preset when it is not set
+func (c *Config) ResolveNetworks() error {
+	if c.Server.Network != "" {
+		p, err := LookupNetwork(c.Server.Network, c.NetworkPresets)
+		if err != nil {
+			return errors.Wrap(err, "server.network")
+		}
+		c.Server.NetworkMagic = p.NetworkMagic
+	}
+	if c.Server.DefaultPeer == "" {
+		c.Server.DefaultPeer = c.defaultPeer(c.Server.NetworkMagic)
+	}
+	for i := range c.Networks {
+		n := &c.Networks[i]
+		if n.Network != "" {
+			p, err := LookupNetwork(n.Network, c.NetworkPresets)
+			if err != nil {
+				return errors.Wrapf(err, "networks[%d].network", i)
+			}
+			n.NetworkMagic = p.NetworkMagic
+			if n.Name == "" {
+				n.Name = p.Name
+			}
+		}
+	}
+	return nil
+}
+
+// NetworkConfigs returns one config per served network. Without a networks
+// section, the config itself is the only network.
+func (c *Config) NetworkConfigs() []*Config {
+	if len(c.Networks) == 0 {
+		return []*Config{c}
+	}
+	out := make([]*Config, 0, len(c.Networks))
+	for _, n := range c.Networks {
+		config := *c
+		config.Networks = nil
+		config.Server.NetworkMagic = n.NetworkMagic
+		// the default peer of the server section only serves its own network
+		if n.DefaultPeer != "" {
+			config.Server.DefaultPeer = n.DefaultPeer
+		} else if n.NetworkMagic != c.Server.NetworkMagic {
+			config.Server.DefaultPeer = c.defaultPeer(n.NetworkMagic)
+		}
+		if n.SnapshotStakePercent != 0 {
+			config.Server.SnapshotStakePercent = n.SnapshotStakePercent
+		}
+		if n.Client.Enabled != nil {
+			config.Client.Enabled = *n.Client.Enabled
+		}
+		if n.Client.Endpoint != "" {
+			config.Client.Endpoint = n.Client.Endpoint
+		}
+		if n.Client.PeriodSeconds != 0 {
+			config.Client.PeriodSeconds = n.Client.PeriodSeconds
+		}
+		if n.Client.FetchMaximum != 0 {
+			config.Client.FetchMaximum = n.Client.FetchMaximum
+		}
+		if n.Client.ProbeTimeout != 0 {
+			config.Client.ProbeTimeout = n.Client.ProbeTimeout
+		}
+		if n.Client.ProbeMode != "" {
+			config.Client.ProbeMode = n.Client.ProbeMode
+		}
+		out = append(out, &config)
+	}
+	return out
 }
 
 // DefaultConfig returns a config with defaults set
@@ -61,11 +225,16 @@ func DefaultConfig() *Config {
 	return &Config{
 		Debug: false,
 		Server: ServerConfig{
-			ListenAddress: defaultListenAddr,
-			ReadTimeout:   defaultReadTimeout,
-			MaxPeers:      defaultMaximumPeers,
-			DefaultPeer:   defaultPeerAddr,
-			NetworkMagic:  testnetMagic,
+			ListenAddress:        defaultListenAddr,
+			ReadTimeout:          defaultReadTimeout,
+			MaxPeers:             defaultMaximumPeers,
+			NetworkMagic:         MainnetMagic,
+			SnapshotStakePercent: defaultSnapshotStake,
+			Gossip: GossipConfig{
+				Interval: defaultGossipInterval,
+				MaxAge:   defaultGossipMaxAge,
+				Quorum:   1,
+			},
 		},
 		Client: ClientConfig{
 			Enabled:       true,
@@ -73,30 +242,46 @@ func DefaultConfig() *Config {
 			FetchMaximum:  defaultFetchMaximum,
 			Endpoint:      defaultClientEndpoint,
 			ProbeTimeout:  defaultProbeTimeout,
+			ProbeMode:     ProbeModeTCP,
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			Endpoint:    defaultTracingAddr,
+			SampleRatio: 1,
+			ServiceName: defaultServiceName,
 		},
 	}
 }
 
-// Load config
+// Load config from, in increasing order of precedence, the defaults of c,
+// the config file if any, P2P_* environment variables and bound flags
 func (c *Config) Load(configFile string) error {
 	log.Debugf("reading config from: %s", configFile)
 
+	SetDefaults(c)
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// Config file not found; ignore error if desired
-			return errors.Wrap(err, "config file not found")
+			log.Debugf("no config file found, using defaults and environment variables")
 		} else {
 			// Config file was found but another error was produced
 			return errors.Wrap(err, "could not read config file")
 		}
 	}
+	settings := viper.AllSettings()
+	for _, warning := range deprecatedWarnings(settings) {
+		log.Warnf("%s", warning)
+	}
+	problems := commandKeys(settings)
+	problems = append(problems, unknownKeys("", settings, reflect.TypeOf(*c))...)
 	if err := viper.Unmarshal(c); err != nil {
-		return errors.Wrap(err, "bad config file format")
+		problems = append(problems, fmt.Sprintf("bad config file format: %v", err))
+	} else if err := c.ResolveNetworks(); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		problems = append(problems, c.Problems()...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
-	return c.Validate()
-}
-
-// Validate validates the config
-func (c *Config) Validate() error {
 	return nil
 }
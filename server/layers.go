@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	EnvPrefix = "P2P"
+
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// secretKeys are masked by Settings
+var secretKeys = map[string]bool{
+	"server.gossip.token": true,
+	"server.tip-token":    true,
+}
+
+// secretWords mark the other secret keys, such as subscribe.password,
+// by the last segment of their name
+var secretWords = []string{"password", "token", "auth"}
+
+// isSecret returns true if the value of key must be masked
+func isSecret(key string) bool {
+	if secretKeys[key] {
+		return true
+	}
+	name := key[strings.LastIndex(key, ".")+1:]
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret returns value, masked if key is a secret
+func maskSecret(key string, value string) string {
+	if isSecret(key) && value != "" {
+		return "********"
+	}
+	return value
+}
+
+// derivedKeys are set by ResolveNetworks when they are not configured, from their
+// parent keys in order of precedence. Settings reports them with the source of their parent.
+var derivedKeys = []struct {
+	key     string
+	parents []string
+}{
+	{"server.magic", []string{"server.network"}},
+	{"server.default-peer", []string{"server.network", "server.magic"}},
+}
+
+// commandFlags lists the flags of each command that can be set
+// in the config file section named after the command
+var commandFlags = make(map[string]map[string]bool)
+
+// RegisterCommandFlags declares the flags of command, so that the command section
+// of the config file is accepted by the strict decoding
+func RegisterCommandFlags(command string, flags []string) {
+	names := make(map[string]bool, len(flags))
+	for _, name := range flags {
+		names[name] = true
+	}
+	commandFlags[command] = names
+}
+
+// EnvKey returns the environment variable overriding a config key,
+// eg. P2P_SERVER_MAX_PEERS for server.max-peers
+func EnvKey(key string) string {
+	r := strings.NewReplacer(".", "_", "-", "_")
+	return EnvPrefix + "_" + strings.ToUpper(r.Replace(key))
+}
+
+// flatten returns the leaf values of v keyed by their dotted config key.
+// Slices of structs are leaves.
+func flatten(prefix string, v reflect.Value, out map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := mapstructureKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)) {
+			flatten(prefix+key+".", field, out)
+			continue
+		}
+		out[prefix+key] = field.Interface()
+	}
+}
+
+// SetDefaults registers the values of c as viper defaults. Keys become known
+// to viper, so that environment variables override nested keys too.
+func SetDefaults(c *Config) {
+	values := make(map[string]interface{})
+	flatten("", reflect.ValueOf(*c), values)
+	for key, value := range values {
+		viper.SetDefault(key, value)
+	}
+}
+
+// Setting is the effective value of a config key along with its source
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// keySource returns where viper read key from: a bound flag, the environment,
+// the config file or the defaults
+func keySource(key string, file *viper.Viper, flags map[string]bool) string {
+	if flags[key] {
+		return SourceFlag
+	}
+	if _, ok := os.LookupEnv(EnvKey(key)); ok {
+		return SourceEnv
+	}
+	if file != nil && file.IsSet(key) {
+		return SourceFile
+	}
+	return SourceDefault
+}
+
+// configFileViper returns a viper reading the config file only, or nil without a config file
+func configFileViper() *viper.Viper {
+	configFile := viper.ConfigFileUsed()
+	if configFile == "" {
+		return nil
+	}
+	file := viper.New()
+	file.SetConfigFile(configFile)
+	if err := file.ReadInConfig(); err != nil {
+		return nil
+	}
+	return file
+}
+
+// Settings returns the effective value of every key of c, sorted by key.
+// changedFlags are the config keys set by a command line flag.
+func (c *Config) Settings(changedFlags map[string]bool) []Setting {
+	file := configFileViper()
+	values := make(map[string]interface{})
+	flatten("", reflect.ValueOf(*c), values)
+	keys := make([]string, 0, len(values))
+	sources := make(map[string]string, len(values))
+	for key := range values {
+		keys = append(keys, key)
+		sources[key] = keySource(key, file, changedFlags)
+	}
+	for _, derived := range derivedKeys {
+		if sources[derived.key] != SourceDefault {
+			continue
+		}
+		for _, parent := range derived.parents {
+			if sources[parent] != SourceDefault {
+				sources[derived.key] = sources[parent]
+				break
+			}
+		}
+	}
+	sort.Strings(keys)
+	out := make([]Setting, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, Setting{
+			Key:    key,
+			Value:  maskSecret(key, formatValue(values[key])),
+			Source: sources[key],
+		})
+	}
+	return out
+}
+
+// CommandSettings returns the effective value of the flags of the command sections,
+// given the default value of each flag by config key, eg. fetch.max, sorted by key.
+// Flags set on the command line are not known here and are not reported.
+func CommandSettings(defaults map[string]string) []Setting {
+	file := configFileViper()
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]Setting, 0, len(keys))
+	for _, key := range keys {
+		setting := Setting{Key: key, Value: defaults[key], Source: keySource(key, file, nil)}
+		if setting.Source != SourceDefault {
+			setting.Value = formatValue(viper.Get(key))
+		}
+		setting.Value = maskSecret(key, setting.Value)
+		out = append(out, setting)
+	}
+	return out
+}
+
+// DeprecatedSettings returns the deprecated keys that are still set, sorted by key
+func DeprecatedSettings() []Setting {
+	file := configFileViper()
+	out := make([]Setting, 0)
+	for key := range deprecatedKeys {
+		if !viper.IsSet(key) {
+			continue
+		}
+		out = append(out, Setting{
+			Key:    key,
+			Value:  maskSecret(key, formatValue(viper.Get(key))),
+			Source: keySource(key, file, nil),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case time.Duration:
+		return value.String()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		if rv.Len() == 0 {
+			return "[]"
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
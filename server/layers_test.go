@@ -0,0 +1,143 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func resetViper(t *testing.T) {
+	viper.Reset()
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+	t.Cleanup(viper.Reset)
+}
+
+func TestEnvKey(t *testing.T) {
+	require.Equal(t, "P2P_SERVER_MAX_PEERS", EnvKey("server.max-peers"))
+	require.Equal(t, "P2P_SERVER_GOSSIP_TOKEN", EnvKey("server.gossip.token"))
+}
+
+func TestLoadLayers(t *testing.T) {
+	resetViper(t)
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("server:\n  max-peers: 15\n  read-timeout: 2s\n"), 0644))
+	viper.SetConfigFile(configFile)
+	os.Setenv("P2P_SERVER_MAX_PEERS", "20")
+	os.Setenv("P2P_SERVER_GOSSIP_TOKEN", "secret")
+	defer os.Unsetenv("P2P_SERVER_MAX_PEERS")
+	defer os.Unsetenv("P2P_SERVER_GOSSIP_TOKEN")
+
+	config := DefaultConfig()
+	require.NoError(t, config.Load(configFile))
+	require.Equal(t, 20, config.Server.MaxPeers)
+	require.Equal(t, "secret", config.Server.Gossip.Token)
+
+	sources := make(map[string]Setting)
+	for _, s := range config.Settings(nil) {
+		sources[s.Key] = s
+	}
+	require.Equal(t, SourceEnv, sources["server.max-peers"].Source)
+	require.Equal(t, SourceFile, sources["server.read-timeout"].Source)
+	require.Equal(t, "2s", sources["server.read-timeout"].Value)
+	require.Equal(t, SourceDefault, sources["server.listen-addr"].Source)
+	require.Equal(t, "********", sources["server.gossip.token"].Value)
+}
+
+func TestLoadWithoutConfigFile(t *testing.T) {
+	resetViper(t)
+	viper.AddConfigPath(t.TempDir())
+	viper.SetConfigName(".cardano-p2p")
+	config := DefaultConfig()
+	require.NoError(t, config.Load(""))
+	require.Equal(t, MainnetMagic, config.Server.NetworkMagic)
+}
+
+func TestCommandKeys(t *testing.T) {
+	RegisterCommandFlags("fetch", []string{"max", "network"})
+	defer delete(commandFlags, "fetch")
+	settings := map[string]interface{}{
+		"fetch":  map[string]interface{}{"max": 5, "maxx": 3},
+		"server": map[string]interface{}{},
+	}
+	require.Equal(t, []string{"fetch.maxx: unknown flag of the fetch command"}, commandKeys(settings))
+	require.NotContains(t, settings, "fetch")
+}
+
+func TestLoadDeprecatedPassword(t *testing.T) {
+	resetViper(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("password: secret\nserver:\n  max-peers: 15\n"), 0644))
+	viper.SetConfigFile(configFile)
+
+	config := DefaultConfig()
+	require.NoError(t, config.Load(configFile))
+	require.Equal(t, 15, config.Server.MaxPeers)
+	require.Equal(t, "secret", viper.GetString("password"))
+
+	settings := map[string]interface{}{"password": "secret", "debug": true}
+	require.Equal(t, []string{"password: deprecated, use subscribe.password"}, deprecatedWarnings(settings))
+	require.Equal(t, map[string]interface{}{"debug": true}, settings)
+}
+
+func TestSettingsSources(t *testing.T) {
+	resetViper(t)
+	RegisterCommandFlags("fetch", []string{"max", "network", "out"})
+	defer delete(commandFlags, "fetch")
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("server:\n  network: preprod\nfetch:\n  max: 15\n"), 0644))
+	viper.SetConfigFile(configFile)
+	os.Setenv("P2P_FETCH_NETWORK", "1")
+	defer os.Unsetenv("P2P_FETCH_NETWORK")
+
+	config := DefaultConfig()
+	require.NoError(t, config.Load(configFile))
+
+	sources := make(map[string]Setting)
+	for _, s := range config.Settings(map[string]bool{"server.listen-addr": true}) {
+		sources[s.Key] = s
+	}
+	require.Equal(t, SourceFlag, sources["server.listen-addr"].Source)
+	require.Equal(t, SourceFile, sources["server.magic"].Source)
+	require.Equal(t, SourceFile, sources["server.default-peer"].Source)
+
+	settings := CommandSettings(map[string]string{"fetch.max": "20", "fetch.network": "", "fetch.out": ""})
+	require.Equal(t, []Setting{
+		{Key: "fetch.max", Value: "15", Source: SourceFile},
+		{Key: "fetch.network", Value: "1", Source: SourceEnv},
+		{Key: "fetch.out", Value: "", Source: SourceDefault},
+	}, settings)
+}
+
+func TestSettingsSecrets(t *testing.T) {
+	resetViper(t)
+	RegisterCommandFlags("subscribe", []string{"password", "topic"})
+	defer delete(commandFlags, "subscribe")
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("password: hunter1\nserver:\n  tip-token: hunter3\nsubscribe:\n  password: hunter2\n  topic: p2p\n"), 0644))
+	viper.SetConfigFile(configFile)
+
+	config := DefaultConfig()
+	require.NoError(t, config.Load(configFile))
+
+	settings := config.Settings(nil)
+	settings = append(settings, CommandSettings(map[string]string{"subscribe.password": "", "subscribe.topic": "p2p"})...)
+	settings = append(settings, DeprecatedSettings()...)
+	values := make(map[string]string)
+	for _, s := range settings {
+		require.NotContains(t, s.Value, "hunter")
+		values[s.Key] = s.Value
+	}
+	require.Equal(t, "********", values["server.tip-token"])
+	require.Equal(t, "********", values["subscribe.password"])
+	require.Equal(t, "********", values["password"])
+	require.Equal(t, "p2p", values["subscribe.topic"])
+	require.Equal(t, "", values["server.gossip.token"])
+}
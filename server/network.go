@@ -0,0 +1,89 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	NetworkMainnet   = "mainnet"
+	NetworkPreprod   = "preprod"
+	NetworkPreview   = "preview"
+	NetworkSanchonet = "sanchonet"
+	NetworkCustom    = "custom"
+
+	MainnetMagic = uint64(764824073)
+)
+
+// NetworkPreset describes a Cardano network: its magic, the bootstrap peers
+// of its trusted relays and the topology endpoints known to serve it
+type NetworkPreset struct {
+	Name           string   `mapstructure:"name,omitempty" json:"name"`
+	NetworkMagic   uint64   `mapstructure:"magic,omitempty" json:"magic"`
+	BootstrapPeers []string `mapstructure:"bootstrap-peers,omitempty" json:"bootstrapPeers,omitempty"`
+	Endpoints      []string `mapstructure:"endpoints,omitempty" json:"endpoints,omitempty"`
+}
+
+// BuiltinNetworks are the public Cardano networks
+var BuiltinNetworks = []NetworkPreset{
+	{
+		Name:         NetworkMainnet,
+		NetworkMagic: MainnetMagic,
+		BootstrapPeers: []string{
+			"backbone.cardano.iog.io:3001",
+			"backbone.mainnet.emurgornd.com:3001",
+			"backbone.mainnet.cardanofoundation.org:3001",
+		},
+		Endpoints: []string{"https://api.clio.one"},
+	},
+	{
+		Name:           NetworkPreprod,
+		NetworkMagic:   1,
+		BootstrapPeers: []string{"preprod-node.play.dev.cardano.org:3001"},
+	},
+	{
+		Name:           NetworkPreview,
+		NetworkMagic:   2,
+		BootstrapPeers: []string{"preview-node.play.dev.cardano.org:3001"},
+	},
+	{
+		Name:           NetworkSanchonet,
+		NetworkMagic:   4,
+		BootstrapPeers: []string{"sanchonet-node.play.dev.cardano.org:3001"},
+	},
+}
+
+// LookupNetwork returns the preset named s, or the preset with magic s if s is a number.
+// Custom presets take precedence over the built-in ones. An unknown magic returns
+// a "custom" preset without bootstrap peers nor endpoints.
+func LookupNetwork(s string, custom []NetworkPreset) (NetworkPreset, error) {
+	presets := append(append([]NetworkPreset{}, custom...), BuiltinNetworks...)
+	if magic, err := strconv.ParseUint(s, 10, 64); err == nil {
+		for _, p := range presets {
+			if p.NetworkMagic == magic {
+				return p, nil
+			}
+		}
+		return NetworkPreset{Name: NetworkCustom, NetworkMagic: magic}, nil
+	}
+	for _, p := range presets {
+		if strings.EqualFold(p.Name, s) {
+			return p, nil
+		}
+	}
+	return NetworkPreset{}, errors.Errorf("unknown network '%s', use one of %s or a network magic", s, strings.Join(NetworkNames(custom), ", "))
+}
+
+// NetworkNames returns the names of the custom and built-in presets
+func NetworkNames(custom []NetworkPreset) []string {
+	out := make([]string, 0, len(custom)+len(BuiltinNetworks))
+	for _, p := range custom {
+		out = append(out, p.Name)
+	}
+	for _, p := range BuiltinNetworks {
+		out = append(out, p.Name)
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupNetwork(t *testing.T) {
+	p, err := LookupNetwork("Preprod", nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), p.NetworkMagic)
+
+	p, err = LookupNetwork("764824073", nil)
+	require.NoError(t, err)
+	require.Equal(t, NetworkMainnet, p.Name)
+
+	p, err = LookupNetwork("1097911063", nil)
+	require.NoError(t, err)
+	require.Equal(t, NetworkCustom, p.Name)
+	require.Empty(t, p.BootstrapPeers)
+
+	custom := []NetworkPreset{{Name: "devnet", NetworkMagic: 42, BootstrapPeers: []string{"relay.example.com:3001"}}}
+	p, err = LookupNetwork("devnet", custom)
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), p.NetworkMagic)
+
+	_, err = LookupNetwork("testnet", custom)
+	require.Error(t, err)
+}
+
+func TestResolveNetworks(t *testing.T) {
+	config := DefaultConfig()
+	config.Server.Network = "preview"
+	config.NetworkPresets = []NetworkPreset{{Name: "devnet", NetworkMagic: 42, BootstrapPeers: []string{"relay.example.com:3001"}}}
+	config.Networks = []NetworkConfig{{Network: "devnet"}, {NetworkMagic: 4, DefaultPeer: "10.0.0.1:3001"}}
+	require.NoError(t, config.ResolveNetworks())
+	require.NoError(t, config.Validate())
+	require.Equal(t, uint64(2), config.Server.NetworkMagic)
+	require.Equal(t, "preview-node.play.dev.cardano.org:3001", config.Server.DefaultPeer)
+
+	configs := config.NetworkConfigs()
+	require.Len(t, configs, 2)
+	require.Equal(t, uint64(42), configs[0].Server.NetworkMagic)
+	require.Equal(t, "relay.example.com:3001", configs[0].Server.DefaultPeer)
+	require.Equal(t, "10.0.0.1:3001", configs[1].Server.DefaultPeer)
+
+	config.Server.Network = "unknown"
+	require.Error(t, config.ResolveNetworks())
+}
+
+func TestNetworkDefaultPeer(t *testing.T) {
+	config := DefaultConfig()
+	config.Server.DefaultPeer = "10.0.0.1:3001"
+	config.Networks = []NetworkConfig{{Network: "mainnet"}, {Network: "preprod"}, {NetworkMagic: 42}}
+	require.NoError(t, config.ResolveNetworks())
+
+	configs := config.NetworkConfigs()
+	require.Len(t, configs, 3)
+	require.Equal(t, "10.0.0.1:3001", configs[0].Server.DefaultPeer)
+	require.Equal(t, "preprod-node.play.dev.cardano.org:3001", configs[1].Server.DefaultPeer)
+	require.Empty(t, configs[2].Server.DefaultPeer)
+}
+
+func TestNetworkClientEnabled(t *testing.T) {
+	resetViper(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(`
+client:
+  enabled: true
+networks:
+  - network: mainnet
+  - network: preprod
+    client:
+      enabled: false
+`), 0644))
+	viper.SetConfigFile(configFile)
+	config := DefaultConfig()
+	require.NoError(t, config.Load(configFile))
+
+	configs := config.NetworkConfigs()
+	require.Len(t, configs, 2)
+	require.True(t, configs[0].Client.Enabled)
+	require.False(t, configs[1].Client.Enabled)
+	require.True(t, config.Client.Enabled)
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeersLimit is the largest max query parameter accepted by the v1 API
+const maxPeersLimit = 20
+
+// ValidationError lists all the problems found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// Validate validates the config and returns all the problems found at once
+func (c *Config) Validate() error {
+	if problems := c.Problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// mapstructureKey returns the config key of a struct field
+func mapstructureKey(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+}
+
+// unknownKeys returns a problem for each key of settings that does not match
+// a field of t, suggesting the closest known key
+func unknownKeys(prefix string, settings map[string]interface{}, t reflect.Type) []string {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := mapstructureKey(t.Field(i)); key != "" {
+			fields[key] = t.Field(i).Type
+		}
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	problems := make([]string, 0)
+	for _, key := range keys {
+		ft, ok := fields[key]
+		if !ok {
+			problem := fmt.Sprintf("%s%s: unknown key", prefix, key)
+			if suggestion := closestKey(key, fields); suggestion != "" {
+				problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		switch {
+		case ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Duration(0)):
+			if m := toStringMap(settings[key]); m != nil {
+				problems = append(problems, unknownKeys(prefix+key+".", m, ft)...)
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			items, _ := settings[key].([]interface{})
+			for i, item := range items {
+				if m := toStringMap(item); m != nil {
+					problems = append(problems, unknownKeys(fmt.Sprintf("%s%s[%d].", prefix, key, i), m, ft.Elem())...)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// deprecatedKeys are the top-level keys still read directly from viper, by their replacement
+var deprecatedKeys = map[string]string{
+	"password": "subscribe.password",
+}
+
+// deprecatedWarnings returns a warning for each deprecated key of settings, and removes them from settings
+func deprecatedWarnings(settings map[string]interface{}) []string {
+	keys := make([]string, 0, len(deprecatedKeys))
+	for key := range deprecatedKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	warnings := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := settings[key]; ok {
+			delete(settings, key)
+			warnings = append(warnings, fmt.Sprintf("%s: deprecated, use %s", key, deprecatedKeys[key]))
+		}
+	}
+	return warnings
+}
+
+// commandKeys checks the command sections of settings against the registered
+// command flags, and removes them from settings
+func commandKeys(settings map[string]interface{}) []string {
+	problems := make([]string, 0)
+	commands := make([]string, 0, len(commandFlags))
+	for command := range commandFlags {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		section, ok := settings[command]
+		if !ok {
+			continue
+		}
+		delete(settings, command)
+		m := toStringMap(section)
+		if m == nil {
+			problems = append(problems, fmt.Sprintf("%s: must be a map of flags", command))
+			continue
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !commandFlags[command][key] {
+				problems = append(problems, fmt.Sprintf("%s.%s: unknown flag of the %s command", command, key, command))
+			}
+		}
+	}
+	return problems
+}
+
+func toStringMap(v interface{}) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[strings.ToLower(fmt.Sprint(k))] = v
+		}
+		return out
+	}
+	return nil
+}
+
+// closestKey returns the known key sharing the longest prefix with key
+func closestKey(key string, fields map[string]reflect.Type) string {
+	best, bestLen := "", 3
+	for known := range fields {
+		n := 0
+		for n < len(key) && n < len(known) && key[n] == known[n] {
+			n++
+		}
+		if n > bestLen || (n == bestLen && best != "" && known < best) {
+			best, bestLen = known, n
+		}
+	}
+	return best
+}
+
+func checkHostPort(s string) error {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("missing host in '%s'", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port '%s'", port)
+	}
+	return nil
+}
+
+func checkURL(s string, schemes ...string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			if u.Host == "" {
+				return fmt.Errorf("missing host in '%s'", s)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' must use one of the schemes %s", s, strings.Join(schemes, ", "))
+}
+
+func clientProblems(prefix string, c *ClientConfig) []string {
+	problems := make([]string, 0)
+	switch c.ProbeMode {
+	case ProbeModeTCP, ProbeModeHandshake:
+	default:
+		problems = append(problems, fmt.Sprintf("%s.probe-mode: unknown mode '%s'", prefix, c.ProbeMode))
+	}
+	if err := checkURL(c.Endpoint, "ws", "wss"); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.endpoint: %v", prefix, err))
+	}
+	if c.PeriodSeconds <= 0 {
+		problems = append(problems, fmt.Sprintf("%s.period-seconds: must be positive", prefix))
+	}
+	if c.ProbeTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("%s.probe-timeout: must be positive", prefix))
+	} else if c.PeriodSeconds > 0 && c.ProbeTimeout >= c.PeriodSeconds {
+		problems = append(problems, fmt.Sprintf("%s.probe-timeout: must be shorter than period-seconds", prefix))
+	}
+	if c.FetchMaximum < 1 {
+		problems = append(problems, fmt.Sprintf("%s.fetch-maximum: must be at least 1", prefix))
+	}
+	return problems
+}
+
+// Problems returns all the problems found in the config
+func (c *Config) Problems() []string {
+	problems := make([]string, 0)
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	srv := c.Server
+	if _, _, err := net.SplitHostPort(srv.ListenAddress); err != nil {
+		add("server.listen-addr: %v", err)
+	}
+	if srv.ReadTimeout <= 0 {
+		add("server.read-timeout: must be positive")
+	}
+	if srv.MaxPeers < 1 || srv.MaxPeers > maxPeersLimit {
+		add("server.max-peers: must be between 1 and %d", maxPeersLimit)
+	}
+	if srv.NetworkMagic == 0 {
+		add("server.magic: must not be 0, set server.magic or server.network")
+	}
+	if srv.DefaultPeer != "" {
+		if err := checkHostPort(srv.DefaultPeer); err != nil {
+			add("server.default-peer: %v", err)
+		}
+	}
+	if srv.SnapshotStakePercent <= 0 || srv.SnapshotStakePercent > 100 {
+		add("server.snapshot-stake-percent: must be greater than 0 and at most 100")
+	}
+	tls := srv.TLS
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		add("server.tls: cert-file and key-file must be set together")
+	}
+	if (tls.ClientCAFile != "" || tls.RequireClientCert) && !tls.Enabled() {
+		add("server.tls: client certificates require cert-file and key-file")
+	}
+	if tls.RequireClientCert && tls.ClientCAFile == "" {
+		add("server.tls: require-client-cert needs a client-ca-file")
+	}
+	gossip := srv.Gossip
+	if gossip.Enabled {
+		if gossip.Token == "" && tls.ClientCAFile == "" {
+			add("server.gossip: a token or server.tls.client-ca-file is required to authenticate peers")
+		}
+		if gossip.Quorum < 1 || gossip.Quorum > len(gossip.Peers)+1 {
+			add("server.gossip.quorum: must be between 1 and the number of peers plus one")
+		}
+		if gossip.Interval <= 0 || gossip.MaxAge <= 0 {
+			add("server.gossip: interval and max-age must be positive")
+		} else if gossip.MaxAge < gossip.Interval {
+			add("server.gossip.max-age: must not be shorter than interval")
+		}
+		if (gossip.ClientCertFile == "") != (gossip.ClientKeyFile == "") {
+			add("server.gossip: client-cert-file and client-key-file must be set together")
+		}
+		for i, peer := range gossip.Peers {
+			if err := checkURL(peer, "http", "https"); err != nil {
+				add("server.gossip.peers[%d]: %v", i, err)
+			}
+		}
+	}
+
+	if c.Client.Enabled {
+		problems = append(problems, clientProblems("client", &c.Client)...)
+	}
+
+	names := make(map[string]bool, len(c.NetworkPresets))
+	for i, p := range c.NetworkPresets {
+		if p.Name == "" || p.NetworkMagic == 0 {
+			add("network-presets[%d]: name and magic are required", i)
+		}
+		if _, err := strconv.ParseUint(p.Name, 10, 64); err == nil {
+			add("network-presets[%d].name: must not be a number", i)
+		}
+		if names[strings.ToLower(p.Name)] {
+			add("network-presets[%d].name: '%s' is defined twice", i, p.Name)
+		}
+		names[strings.ToLower(p.Name)] = true
+		for j, peer := range p.BootstrapPeers {
+			if err := checkHostPort(peer); err != nil {
+				add("network-presets[%d].bootstrap-peers[%d]: %v", i, j, err)
+			}
+		}
+		for j, endpoint := range p.Endpoints {
+			if err := checkURL(endpoint, "http", "https"); err != nil {
+				add("network-presets[%d].endpoints[%d]: %v", i, j, err)
+			}
+		}
+	}
+
+	magics := make(map[uint64]bool, len(c.Networks))
+	for i, n := range c.Networks {
+		if n.NetworkMagic == 0 {
+			add("networks[%d]: magic or network is required", i)
+		} else if magics[n.NetworkMagic] {
+			add("networks[%d].magic: %d is served twice", i, n.NetworkMagic)
+		}
+		magics[n.NetworkMagic] = true
+		if n.DefaultPeer != "" {
+			if err := checkHostPort(n.DefaultPeer); err != nil {
+				add("networks[%d].default-peer: %v", i, err)
+			}
+		}
+		if n.SnapshotStakePercent < 0 || n.SnapshotStakePercent > 100 {
+			add("networks[%d].snapshot-stake-percent: must be greater than 0 and at most 100", i)
+		}
+	}
+	if len(c.Networks) > 0 {
+		for i, network := range c.NetworkConfigs() {
+			if network.Client.Enabled && network.Client != c.Client {
+				problems = append(problems, clientProblems(fmt.Sprintf("networks[%d].client", i), &network.Client)...)
+			}
+		}
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample-ratio: must be between 0 and 1")
+	}
+	if c.Tracing.Enabled {
+		if err := checkHostPort(c.Tracing.Endpoint); err != nil {
+			add("tracing.endpoint: %v", err)
+		}
+		if c.Tracing.ServiceName == "" {
+			add("tracing.service-name: must not be empty")
+		}
+	}
+	return problems
+}
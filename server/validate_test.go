@@ -0,0 +1,41 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	settings := map[string]interface{}{
+		"debug": true,
+		"client": map[string]interface{}{
+			"fetch-max": 8000,
+		},
+		"networks": []interface{}{
+			map[interface{}]interface{}{"network": "preprod", "magik": 1},
+		},
+		"trace": map[string]interface{}{},
+	}
+	require.Equal(t, []string{
+		"client.fetch-max: unknown key, did you mean 'fetch-maximum'?",
+		"networks[0].magik: unknown key, did you mean 'magic'?",
+		"trace: unknown key, did you mean 'tracing'?",
+	}, unknownKeys("", settings, reflect.TypeOf(Config{})))
+}
+
+func TestProblems(t *testing.T) {
+	require.NoError(t, DefaultConfig().Validate())
+
+	config := DefaultConfig()
+	config.Server.MaxPeers = 0
+	config.Server.DefaultPeer = "relay.example.com"
+	config.Client.Endpoint = "http://localhost:1337"
+	config.Client.ProbeTimeout = config.Client.PeriodSeconds
+	config.Tracing.Enabled = true
+	config.Tracing.Endpoint = ""
+	err := config.Validate()
+	require.Error(t, err)
+	require.Len(t, err.(*ValidationError).Problems, 5)
+}